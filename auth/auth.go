@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
@@ -15,13 +16,34 @@ const (
 	DefaultMaximumJWTNotExpiredPeriod = time.Minute * 60
 )
 
-const pemBlockTypeCertificate = "CERTIFICATE"
+const (
+	pemBlockTypeCertificate   = "CERTIFICATE"
+	pemBlockTypeECPrivateKey  = "EC PRIVATE KEY"
+	pemBlockTypePrivateKey    = "PRIVATE KEY"
+	pemBlockTypePublicKey     = "PUBLIC KEY"
+	pemBlockTypeRSAPrivateKey = "RSA PRIVATE KEY"
+	pemBlockTypeRSAPublicKey  = "RSA PUBLIC KEY"
+)
 
 // ParseCertificate parses a single X509 certificate from the PEM-encoded data. If the data has multiple X509 certificates then an error is
 // returned.
 func ParseCertificate(pemString string) (*x509.Certificate, error) {
+	certificates, err := ParseCertificates(pemString)
+	if err != nil {
+		return nil, err
+	}
+	if len(certificates) > 1 {
+		return nil, fmt.Errorf("data has multiple certificates")
+	}
+	return certificates[0], nil
+}
+
+// ParseCertificates parses all X509 certificates from the PEM-encoded data, in the order in which they appear. This is useful for
+// certificate chains and CA bundles. PEM blocks that are not certificates are ignored. If the data has no X509 certificates then an
+// error is returned.
+func ParseCertificates(pemString string) ([]*x509.Certificate, error) {
 	pemBytes := []byte(pemString)
-	var certificate1 *x509.Certificate
+	var certificates []*x509.Certificate
 	for {
 		var block *pem.Block
 		block, pemBytes = pem.Decode(pemBytes)
@@ -29,18 +51,106 @@ func ParseCertificate(pemString string) (*x509.Certificate, error) {
 			break
 		}
 		if block.Type == pemBlockTypeCertificate {
-			certificate2, err := x509.ParseCertificate(block.Bytes)
+			certificate, err := x509.ParseCertificate(block.Bytes)
 			if err != nil {
 				return nil, fmt.Errorf("got error while parsing certificate PEM block: %w", err)
 			}
-			if certificate1 != nil {
-				return nil, fmt.Errorf("data has multiple certificates")
-			}
-			certificate1 = certificate2
+			certificates = append(certificates, certificate)
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("data has no certificate PEM blocks")
+	}
+	return certificates, nil
+}
+
+// VerifyCertificateChain verifies that the first certificate of certificates chains up to one of roots at time currentTime, where the
+// other certificates are used as intermediates (for example, as parsed by ParseCertificates). If roots is nil then the system's roots
+// are used. keyUsages are the acceptable extended key usages of the first certificate, where no keyUsages means
+// x509.ExtKeyUsageServerAuth (see x509.VerifyOptions). The verified chains are returned.
+func VerifyCertificateChain(certificates []*x509.Certificate, roots *x509.CertPool, currentTime time.Time,
+	keyUsages ...x509.ExtKeyUsage) ([][]*x509.Certificate, error) {
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("certificates must not be empty")
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	chains, err := certificates[0].Verify(x509.VerifyOptions{
+		CurrentTime:   currentTime,
+		Intermediates: intermediates,
+		KeyUsages:     keyUsages,
+		Roots:         roots,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error verifying certificate chain: %w", err)
+	}
+	return chains, nil
+}
+
+// ParsePrivateKey parses a single private key from the PEM-encoded data. Supported PEM block types are "RSA PRIVATE KEY" (PKCS #1),
+// "PRIVATE KEY" (PKCS #8) and "EC PRIVATE KEY" (SEC 1). Other PEM blocks (for example certificates) are ignored. If the data has
+// multiple private keys then an error is returned.
+// The returned key is one of *rsa.PrivateKey, *ecdsa.PrivateKey and ed25519.PrivateKey.
+func ParsePrivateKey(pemString string) (crypto.PrivateKey, error) {
+	return parseKey(pemString, "private", func(block *pem.Block) (interface{}, bool, error) {
+		switch block.Type {
+		case pemBlockTypeRSAPrivateKey:
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			return key, true, err
+		case pemBlockTypePrivateKey:
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			return key, true, err
+		case pemBlockTypeECPrivateKey:
+			key, err := x509.ParseECPrivateKey(block.Bytes)
+			return key, true, err
+		}
+		return nil, false, nil
+	})
+}
+
+// ParsePublicKey parses a single public key from the PEM-encoded data. Supported PEM block types are "PUBLIC KEY" (PKIX) and
+// "RSA PUBLIC KEY" (PKCS #1). Other PEM blocks are ignored. If the data has multiple public keys then an error is returned.
+// The returned key is one of *rsa.PublicKey, *ecdsa.PublicKey and ed25519.PublicKey.
+func ParsePublicKey(pemString string) (crypto.PublicKey, error) {
+	return parseKey(pemString, "public", func(block *pem.Block) (interface{}, bool, error) {
+		switch block.Type {
+		case pemBlockTypePublicKey:
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			return key, true, err
+		case pemBlockTypeRSAPublicKey:
+			key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			return key, true, err
+		}
+		return nil, false, nil
+	})
+}
+
+// parseKey parses a single key from the PEM-encoded data, where parseBlock returns whether a block is a key and the parsed key.
+func parseKey(pemString, kind string, parseBlock func(block *pem.Block) (key interface{}, ok bool, err error)) (interface{}, error) {
+	pemBytes := []byte(pemString)
+	var key1 interface{}
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		key2, ok, err := parseBlock(block)
+		if !ok {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("got error while parsing %s key PEM block of type %#v: %w", kind, block.Type, err)
+		}
+		if key1 != nil {
+			return nil, fmt.Errorf("data has multiple %s keys", kind)
 		}
+		key1 = key2
 	}
-	if certificate1 != nil {
-		return certificate1, nil
+	if key1 != nil {
+		return key1, nil
 	}
-	return nil, fmt.Errorf("data has no certificate PEM blocks")
+	return nil, fmt.Errorf("data has no %s key PEM blocks", kind)
 }
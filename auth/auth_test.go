@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTimeNow = time.Date(2020, 5, 16, 0, 0, 0, 0, time.UTC)
+
+func newTestCertificate(t *testing.T, serialNumber int64, isCA bool, parent *x509.Certificate, parentKey crypto.Signer) (
+	*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		BasicConstraintsValid: true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		NotAfter:              testTimeNow.Add(time.Hour),
+		NotBefore:             testTimeNow.Add(-time.Hour),
+		SerialNumber:          big.NewInt(serialNumber),
+		Subject:               pkix.Name{CommonName: big.NewInt(serialNumber).String()},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+func encodePEM(blockType string, data []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}))
+}
+
+func Test_ParseCertificates_VerifyCertificateChain(t *testing.T) {
+	root, rootKey := newTestCertificate(t, 1, true, nil, nil)
+	intermediate, intermediateKey := newTestCertificate(t, 2, true, root, rootKey)
+	leaf, leafKey := newTestCertificate(t, 3, false, intermediate, intermediateKey)
+	leafKeyDER, err := x509.MarshalPKCS8PrivateKey(leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := encodePEM(pemBlockTypePrivateKey, leafKeyDER) + encodePEM(pemBlockTypeCertificate, leaf.Raw) +
+		encodePEM(pemBlockTypeCertificate, intermediate.Raw)
+	certificates, err := ParseCertificates(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certificates) != 2 || !certificates[0].Equal(leaf) || !certificates[1].Equal(intermediate) {
+		t.Fatalf("unexpected certificates %v", certificates)
+	}
+	if _, err := ParseCertificate(data); err == nil {
+		t.Fatal("expected error because data has multiple certificates")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	chains, err := VerifyCertificateChain(certificates, roots, testTimeNow, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chains) != 1 || len(chains[0]) != 3 {
+		t.Fatalf("unexpected chains %v", chains)
+	}
+	if _, err := VerifyCertificateChain(certificates[:1], roots, testTimeNow, x509.ExtKeyUsageClientAuth); err == nil {
+		t.Fatal("expected error because intermediate is missing")
+	}
+	if _, err := VerifyCertificateChain(certificates, roots, testTimeNow.Add(time.Hour*2), x509.ExtKeyUsageClientAuth); err == nil {
+		t.Fatal("expected error because certificates are expired")
+	}
+	privateKey, err := ParsePrivateKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leafKey.(*ecdsa.PrivateKey).Equal(privateKey) {
+		t.Fatal("unexpected private key")
+	}
+}
+
+func Test_ParsePrivateKey_ParsePublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKeyDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519PublicKey, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519KeyDER, err := x509.MarshalPKCS8PrivateKey(ed25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519PublicKeyDER, err := x509.MarshalPKIXPublicKey(ed25519PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		data     string
+		expected interface{ Equal(crypto.PrivateKey) bool }
+	}{
+		{encodePEM(pemBlockTypeRSAPrivateKey, x509.MarshalPKCS1PrivateKey(rsaKey)), rsaKey},
+		{encodePEM(pemBlockTypeECPrivateKey, ecKeyDER), ecKey},
+		{encodePEM(pemBlockTypePrivateKey, ed25519KeyDER), ed25519Key},
+	} {
+		key, err := ParsePrivateKey(c.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.expected.Equal(key) {
+			t.Fatalf("unexpected key %v", key)
+		}
+	}
+	for _, c := range []struct {
+		data     string
+		expected interface{ Equal(crypto.PublicKey) bool }
+	}{
+		{encodePEM(pemBlockTypeRSAPublicKey, x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)), &rsaKey.PublicKey},
+		{encodePEM(pemBlockTypePublicKey, ed25519PublicKeyDER), ed25519PublicKey},
+	} {
+		key, err := ParsePublicKey(c.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.expected.Equal(key) {
+			t.Fatalf("unexpected key %v", key)
+		}
+	}
+	data := encodePEM(pemBlockTypeRSAPrivateKey, x509.MarshalPKCS1PrivateKey(rsaKey)) + encodePEM(pemBlockTypeECPrivateKey, ecKeyDER)
+	if _, err := ParsePrivateKey(data); err == nil || !strings.Contains(err.Error(), "multiple") {
+		t.Fatalf("expected error because data has multiple private keys, but got %v", err)
+	}
+	if _, err := ParsePublicKey(data); err == nil {
+		t.Fatal("expected error because data has no public keys")
+	}
+}
@@ -2,14 +2,19 @@ package compute
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-jose/go-jose/v3/jwt"
 	"github.com/hashicorp/go-cleanhttp"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/cloudresourcemanager/v1"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iam/v1"
@@ -17,6 +22,9 @@ import (
 
 	"github.com/jbrekelmans/go-lib/auth"
 	"github.com/jbrekelmans/go-lib/auth/google"
+	jasperjwt "github.com/jbrekelmans/go-lib/auth/jwt"
+	"github.com/jbrekelmans/go-lib/cache"
+	"github.com/jbrekelmans/go-lib/clock"
 )
 
 const (
@@ -29,7 +37,11 @@ const (
 // InstanceGetter is an abstraction for Google's Golang compute engine service for the purpose of unit testing.
 type InstanceGetter = func(ctx context.Context, projectID, zone, instanceName string) (*compute.Instance, error)
 
+// ProjectGetter is an abstraction for Google's Golang cloud resource manager service for the purpose of unit testing.
+type ProjectGetter = func(ctx context.Context, projectID string) (*cloudresourcemanager.Project, error)
+
 // InstanceIdentityJWTClaims has holds the claims of an instance identity JWT token that are not in "gopkg.in/square/go-jose.v2/jwt".Claims.
+// Google is nil if the JWT is a standard format token, see WithAllowStandardFormat.
 type InstanceIdentityJWTClaims struct {
 	AuthorizedParty string `json:"azp"`
 	Email           string `json:"email"`
@@ -54,18 +66,43 @@ type InstanceIdentityGCEJWTClaims struct {
 type InstanceIdentity struct {
 	Claims1 *jwt.Claims
 	Claims2 *InstanceIdentityJWTClaims
+	// Instance is the instance as returned by the compute engine API during verification. This is useful for authorization decisions,
+	// for example based on labels. Instance should not be modified, because it may be cached (see WithLookupCache).
+	Instance *compute.Instance
 }
 
 // InstanceIdentityVerifier is type that verifies instance identities. See NewInstanceIdentityVerifier and https://cloud.google.com/compute/docs/instances/verifying-instance-identity.
 type InstanceIdentityVerifier struct {
 	allowNonUserManagedServiceAccounts bool
+	allowStandardFormat                bool
+	allowedProjects                    []string
+	allowedRegions                     []string
+	allowedServiceAccounts             []string
+	allowedZones                       []string
 	audience                           string
+	checkProjectNumber                 bool
+	clock                              clock.Clock
 	computeIntanceGetter               InstanceGetter
+	instanceLookupCache                cache.KeyedCachedEvaluator[instanceKey, lookupResult[*compute.Instance]]
 	jwtClaimsLeeway                    time.Duration
+	keyPolicy                          *google.KeyPolicy
 	keySetProvider                     google.KeySetProvider
+	lookupCacheMaximumSize             int
+	lookupCacheNegativeTimeToLive      time.Duration
+	lookupCacheTimeToLive              time.Duration
+	maximumInstanceAge                 time.Duration
 	maximumJWTNotExpiredPeriod         time.Duration
+	minimumInstanceAge                 time.Duration
+	projectGetter                      ProjectGetter
+	requiredLicenses                   []string
+	requiredLabels                     map[string]string
+	requiredMetadataKeys               []string
+	requiredTags                       []string
+	resultCache                        *resultCache
+	resultCacheMaximumSize             int
+	resultCacheMaximumTimeToLive       time.Duration
 	serviceAccountGetter               google.ServiceAccountGetter
-	timeSource                         func() time.Time
+	verifier                           *jasperjwt.Verifier
 }
 
 // NewInstanceIdentityVerifier is the constructor for InstanceIdentityVerifier. See https://cloud.google.com/compute/docs/instances/verifying-instance-identity.
@@ -80,12 +117,24 @@ func NewInstanceIdentityVerifier(audience string, opts ...InstanceIdentityVerifi
 	for _, opt := range opts {
 		opt(a)
 	}
+	if a.clock == nil {
+		a.clock = clock.System
+	}
 	var defaultHTTPClient *http.Client
 	if a.keySetProvider == nil {
 		defaultHTTPClient = cleanhttp.DefaultPooledClient()
 		a.keySetProvider = google.CachingKeySetProvider(
 			google.DefaultCachingKeySetProviderTimeToLive,
 			google.HTTPSKeySetProvider(defaultHTTPClient),
+			google.WithClock(a.clock),
+			google.WithCacheOptions(
+				cache.WithStaleIfError(google.DefaultCachingKeySetProviderStaleIfError),
+				cache.WithErrorBackoff(
+					google.DefaultCachingKeySetProviderErrorBackoffInitial,
+					google.DefaultCachingKeySetProviderErrorBackoffMaximum,
+					0.5,
+				),
+			),
 		)
 	}
 	var computeService *compute.Service
@@ -103,6 +152,19 @@ func NewInstanceIdentityVerifier(audience string, opts ...InstanceIdentityVerifi
 			return computeService.Instances.Get(project, zone, instance).Context(ctx).Do()
 		}
 	}
+	if a.projectGetter == nil && a.checkProjectNumber {
+		if defaultHTTPClient == nil {
+			defaultHTTPClient = cleanhttp.DefaultPooledClient()
+		}
+		// We hardcode context.Background() here because the context is only used when compiling for app engine.
+		resourceManagerService, err := cloudresourcemanager.NewService(context.Background(), option.WithHTTPClient(defaultHTTPClient))
+		if err != nil {
+			return nil, fmt.Errorf("error creating cloud resource manager service: %w", err)
+		}
+		a.projectGetter = func(ctx context.Context, projectID string) (*cloudresourcemanager.Project, error) {
+			return resourceManagerService.Projects.Get(projectID).Context(ctx).Do()
+		}
+	}
 	var iamService *iam.Service
 	if a.serviceAccountGetter == nil {
 		if defaultHTTPClient == nil {
@@ -118,45 +180,124 @@ func NewInstanceIdentityVerifier(audience string, opts ...InstanceIdentityVerifi
 			return iamService.Projects.ServiceAccounts.Get(name).Context(ctx).Do()
 		}
 	}
-	if a.timeSource == nil {
-		a.timeSource = time.Now
+	if a.lookupCacheTimeToLive > 0 {
+		computeIntanceGetter := a.computeIntanceGetter
+		var cachingComputeInstanceGetter func(ctx context.Context, key instanceKey) (*compute.Instance, error)
+		cachingComputeInstanceGetter, a.instanceLookupCache = newCachingLookup(func(ctx context.Context, key instanceKey) (*compute.Instance, error) {
+			return computeIntanceGetter(ctx, key.project, key.zone, key.instance)
+		}, a.clock, a.lookupCacheTimeToLive, a.lookupCacheNegativeTimeToLive, a.lookupCacheMaximumSize)
+		a.computeIntanceGetter = func(ctx context.Context, project, zone, instance string) (*compute.Instance, error) {
+			return cachingComputeInstanceGetter(ctx, instanceKey{
+				instance: instance,
+				project:  project,
+				zone:     zone,
+			})
+		}
+		a.serviceAccountGetter, _ = newCachingLookup(a.serviceAccountGetter, a.clock, a.lookupCacheTimeToLive,
+			a.lookupCacheNegativeTimeToLive, a.lookupCacheMaximumSize)
+		if a.projectGetter != nil {
+			a.projectGetter, _ = newCachingLookup(a.projectGetter, a.clock, a.lookupCacheTimeToLive, a.lookupCacheNegativeTimeToLive,
+				a.lookupCacheMaximumSize)
+		}
+	}
+	if a.resultCacheMaximumTimeToLive > 0 {
+		a.resultCache = newResultCache(a.resultCacheMaximumSize)
+	}
+	verifier, err := jasperjwt.NewVerifier(
+		a.keySetProvider,
+		jasperjwt.WithAudience(a.audience),
+		jasperjwt.WithClock(a.clock),
+		jasperjwt.WithIssuer(google.JWTIssuer),
+		jasperjwt.WithJWTClaimsLeeway(a.jwtClaimsLeeway),
+		jasperjwt.WithKeyPolicy(a.keyPolicy),
+		jasperjwt.WithMaximumJWTNotExpiredPeriod(a.maximumJWTNotExpiredPeriod),
+	)
+	if err != nil {
+		return nil, err
 	}
+	a.verifier = verifier
 	return a, nil
 }
 
-func (a *InstanceIdentityVerifier) validateClaims1(c *jwt.Claims) error {
-	log.Tracef("Claims1: %+v", c)
-	now := a.timeSource()
-	err := c.ValidateWithLeeway(jwt.Expected{
-		Audience: []string{
-			a.audience,
-		},
-		Issuer: google.JWTIssuer,
-		Time:   now,
-	}, a.jwtClaimsLeeway)
-	if err != nil {
-		return &VerifyError{e: err.Error()}
+// validateAllowLists validates the claims of a full format token against the allow-lists and required licenses.
+func (a *InstanceIdentityVerifier) validateAllowLists(c *InstanceIdentityJWTClaims) error {
+	project := c.Google.ComputeEngine.ProjectID
+	if len(a.allowedProjects) > 0 && !matchesAny(a.allowedProjects, project) {
+		return &VerifyError{e: fmt.Sprintf("instance is in project %#v, which is not allowed", project)}
 	}
-	if c.Expiry == nil {
-		return &VerifyError{e: `JWT does not have required claim "exp"`}
+	zone := c.Google.ComputeEngine.Zone
+	if len(a.allowedZones) > 0 && !matchesAny(a.allowedZones, zone) {
+		return &VerifyError{e: fmt.Sprintf("instance is in zone %#v, which is not allowed", zone)}
+	}
+	if len(a.allowedRegions) > 0 {
+		i := strings.LastIndexByte(zone, '-')
+		if i < 0 {
+			return &VerifyError{e: fmt.Sprintf("JWT claims zone %#v, which is not a valid zone", zone)}
+		}
+		if region := zone[:i]; !matchesAny(a.allowedRegions, region) {
+			return &VerifyError{e: fmt.Sprintf("instance is in region %#v, which is not allowed", region)}
+		}
 	}
-	expiry := c.Expiry.Time()
-	notExpiredPeriod := expiry.Sub(now)
-	if notExpiredPeriod-a.jwtClaimsLeeway > a.maximumJWTNotExpiredPeriod {
-		return &VerifyError{e: fmt.Sprintf(`JWT must expire after at most %v, but it expires after %v`, a.maximumJWTNotExpiredPeriod, notExpiredPeriod-a.jwtClaimsLeeway)}
+	for _, license := range a.requiredLicenses {
+		if !containsString(c.Google.ComputeEngine.LicenseID, license) {
+			return &VerifyError{e: fmt.Sprintf("JWT does not claim required license %#v (the JWT must be requested with licenses=TRUE)",
+				license)}
+		}
 	}
 	return nil
 }
 
-func (a *InstanceIdentityVerifier) validateClaims2(ctx context.Context, c *InstanceIdentityJWTClaims) error {
+// requiresFullFormat returns true if and only if a has constraints that can only be validated for full format tokens.
+func (a *InstanceIdentityVerifier) requiresFullFormat() bool {
+	return len(a.allowedProjects) > 0 || len(a.allowedRegions) > 0 || len(a.allowedZones) > 0 || a.checkProjectNumber ||
+		a.maximumInstanceAge > 0 || a.minimumInstanceAge > 0 || len(a.requiredLabels) > 0 || len(a.requiredLicenses) > 0 ||
+		len(a.requiredMetadataKeys) > 0 || len(a.requiredTags) > 0
+}
+
+// matchesAny returns true if and only if v matches any of patterns (see path.Match).
+func matchesAny(patterns []string, v string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *InstanceIdentityVerifier) validateClaims2(ctx context.Context, c *InstanceIdentityJWTClaims) (*compute.Instance, error) {
 	project := c.Google.ComputeEngine.ProjectID
 	zone := c.Google.ComputeEngine.Zone
 	instance, err := a.computeIntanceGetter(ctx, project, zone, c.Google.ComputeEngine.InstanceName)
 	if err != nil {
 		if googleErr, ok := err.(*googleapi.Error); ok && googleErr.Code >= 500 {
-			return err
+			return nil, err
 		}
-		return &VerifyError{e: fmt.Sprintf("error during get call: %v", err)}
+		return nil, &VerifyError{e: fmt.Sprintf("error during get call: %v", err)}
+	}
+	if err := a.validateInstance(c, instance); err != nil {
+		return nil, err
+	}
+	if a.checkProjectNumber {
+		p, err := a.projectGetter(ctx, project)
+		if err != nil {
+			if googleErr, ok := err.(*googleapi.Error); ok && googleErr.Code >= 500 {
+				return nil, err
+			}
+			return nil, &VerifyError{e: fmt.Sprintf("error during get project call: %v", err)}
+		}
+		if p.ProjectNumber != c.Google.ComputeEngine.ProjectNumber {
+			return nil, &VerifyError{e: fmt.Sprintf("JWT claims project number %d, but it is actually %d",
+				c.Google.ComputeEngine.ProjectNumber, p.ProjectNumber)}
+		}
+	}
+	return instance, nil
+}
+
+func (a *InstanceIdentityVerifier) validateInstance(c *InstanceIdentityJWTClaims, instance *compute.Instance) error {
+	// The instance may have been deleted and recreated with the same name.
+	if instanceID := strconv.FormatUint(instance.Id, 10); instanceID != c.Google.ComputeEngine.InstanceID {
+		return &VerifyError{e: fmt.Sprintf("JWT claims instance ID %#v, but it is actually %#v", c.Google.ComputeEngine.InstanceID,
+			instanceID)}
 	}
 	// Only Running and Stopping are valid, see https://cloud.google.com/compute/docs/instances/instance-life-cycle
 	if instance.Status != InstanceStatusRunning && instance.Status != InstanceStatusStopping {
@@ -186,9 +327,53 @@ func (a *InstanceIdentityVerifier) validateClaims2(ctx context.Context, c *Insta
 	if !found {
 		return &VerifyError{e: fmt.Sprintf("JWT claims email %#v, but the instance has no service account with that email", c.Email)}
 	}
+	age := a.clock.Now().Sub(creationTime)
+	if a.minimumInstanceAge > 0 && age < a.minimumInstanceAge {
+		return &VerifyError{e: fmt.Sprintf("instance must be at least %v old, but it is %v old", a.minimumInstanceAge, age)}
+	}
+	if a.maximumInstanceAge > 0 && age > a.maximumInstanceAge {
+		return &VerifyError{e: fmt.Sprintf("instance must be at most %v old, but it is %v old", a.maximumInstanceAge, age)}
+	}
+	for key, pattern := range a.requiredLabels {
+		value, ok := instance.Labels[key]
+		if !ok {
+			return &VerifyError{e: fmt.Sprintf("instance does not have required label %#v", key)}
+		}
+		if !matchesAny([]string{pattern}, value) {
+			return &VerifyError{e: fmt.Sprintf("instance has label %#v with value %#v, but the value must match %#v", key, value, pattern)}
+		}
+	}
+	for _, tag := range a.requiredTags {
+		if instance.Tags == nil || !containsString(instance.Tags.Items, tag) {
+			return &VerifyError{e: fmt.Sprintf("instance does not have required tag %#v", tag)}
+		}
+	}
+	for _, key := range a.requiredMetadataKeys {
+		found := false
+		if instance.Metadata != nil {
+			for _, item := range instance.Metadata.Items {
+				if item.Key == key {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return &VerifyError{e: fmt.Sprintf("instance does not have required metadata key %#v", key)}
+		}
+	}
 	return nil
 }
 
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *InstanceIdentityVerifier) validateServiceAccountClaims(ctx context.Context, email, uniqueID string) error {
 	serviceAccount, err := a.serviceAccountGetter(ctx, fmt.Sprintf("projects/-/serviceAccounts/%s", uniqueID))
 	if err != nil {
@@ -214,43 +399,91 @@ func (a *InstanceIdentityVerifier) validateServiceAccountClaims(ctx context.Cont
 // Verify authenticates a GCE identity JWT token (see https://cloud.google.com/compute/docs/instances/verifying-instance-identity).
 // If the returned error is a *VerifyError then jwtString was successfully determined to be invalid.
 // Otherwise, if an error is returned, the verification attempt failed.
+// If a result cache is enabled (see WithResultCache) then the returned *InstanceIdentity may be cached and should not be modified.
 func (a *InstanceIdentityVerifier) Verify(ctx context.Context, jwtString string) (*InstanceIdentity, error) {
-	if a.keySetProvider == nil {
+	if a.verifier == nil {
 		return nil, fmt.Errorf("a must be created via NewInstanceIdentityVerifier")
 	}
-	jwtParsed, err := jwt.ParseSigned(jwtString)
-	if err != nil {
-		return nil, &VerifyError{e: fmt.Sprintf("error jwtString as signed JWT: %v", err)}
+	if a.resultCache == nil {
+		return a.verify(ctx, jwtString)
 	}
-	if len(jwtParsed.Headers) != 1 {
-		return nil, &VerifyError{e: "jwtString must encode a JWT with exactly one header"}
+	key := sha256.Sum256([]byte(jwtString))
+	if identity, ok := a.resultCache.get(key, a.clock.Now()); ok {
+		return identity, nil
 	}
-	keySet, err := a.keySetProvider.Get(ctx)
+	identity, err := a.verify(ctx, jwtString)
 	if err != nil {
-		return nil, fmt.Errorf("error getting public key used for JWT signature verification: %w", err)
+		return nil, err
 	}
-	keyID := jwtParsed.Headers[0].KeyID
-	key, ok := keySet[keyID]
-	if !ok {
-		return nil, &VerifyError{e: fmt.Sprintf("no key with identifier %#v exists", keyID)}
+	now := a.clock.Now()
+	expires := now.Add(a.resultCacheMaximumTimeToLive)
+	if jwtExpires := identity.Claims1.Expiry.Time(); jwtExpires.Before(expires) {
+		expires = jwtExpires
+	}
+	a.resultCache.set(&verifyResult{
+		expires:  expires,
+		identity: identity,
+		key:      key,
+	})
+	return identity, nil
+}
+
+// InvalidateResults drops the cached verification results (see WithResultCache) for which predicate returns true, and returns the number
+// of dropped results. For example, this can be used when an instance is known to have been deleted, see InvalidateInstance.
+func (a *InstanceIdentityVerifier) InvalidateResults(predicate func(identity *InstanceIdentity) bool) int {
+	if a.resultCache == nil {
+		return 0
 	}
-	claims1 := &jwt.Claims{}
+	return a.resultCache.invalidateIf(predicate)
+}
+
+// InvalidateInstance drops the cached verification results (see WithResultCache) and the cached lookup (see WithLookupCache) of the
+// instance with name instanceName in project projectID and zone, and returns the number of dropped results.
+func (a *InstanceIdentityVerifier) InvalidateInstance(projectID, zone, instanceName string) int {
+	if a.instanceLookupCache != nil {
+		a.instanceLookupCache.Invalidate(instanceKey{
+			instance: instanceName,
+			project:  projectID,
+			zone:     zone,
+		})
+	}
+	return a.InvalidateResults(func(identity *InstanceIdentity) bool {
+		if identity.Claims2.Google == nil {
+			return false
+		}
+		c := identity.Claims2.Google.ComputeEngine
+		return c.ProjectID == projectID && c.Zone == zone && c.InstanceName == instanceName
+	})
+}
+
+func (a *InstanceIdentityVerifier) verify(ctx context.Context, jwtString string) (*InstanceIdentity, error) {
 	claims2 := &InstanceIdentityJWTClaims{}
-	if err := jwtParsed.Claims(key.PublicKey, claims1, claims2); err != nil {
-		return nil, &VerifyError{e: fmt.Sprintf("error verifying JWT signature or decoding claims: %v", err)}
-	}
-	if err := a.validateClaims1(claims1); err != nil {
+	claims1, err := a.verifier.Verify(ctx, jwtString, claims2)
+	if err != nil {
+		var verifyErr *jasperjwt.VerifyError
+		if errors.As(err, &verifyErr) {
+			return nil, &VerifyError{e: err.Error()}
+		}
 		return nil, err
 	}
 	log.Tracef("Claims2: %+v", claims2)
-	if claims2.Google == nil {
-		return nil, &VerifyError{e: `JWT does not have required claim "google"`}
-	}
-	if claims2.Google.ComputeEngine == nil {
-		return nil, &VerifyError{e: `JWT has claim "google" with an object value, but the object does not have a required entry with key ` +
-			`"compute_engine"`}
+	standardFormat := claims2.Google == nil
+	if standardFormat {
+		if !a.allowStandardFormat {
+			return nil, &VerifyError{e: `JWT does not have required claim "google" (it is a standard format token, but only full format ` +
+				`tokens are allowed)`}
+		}
+		if a.requiresFullFormat() {
+			return nil, &VerifyError{e: `JWT does not have claim "google" (it is a standard format token, but the verifier has instance ` +
+				`constraints that require a full format token)`}
+		}
+	} else {
+		if claims2.Google.ComputeEngine == nil {
+			return nil, &VerifyError{e: `JWT has claim "google" with an object value, but the object does not have a required entry ` +
+				`with key "compute_engine"`}
+		}
+		log.Tracef("Claims2.Google.ComputeEngine: %+v", claims2.Google.ComputeEngine)
 	}
-	log.Tracef("Claims2.Google.ComputeEngine: %+v", claims2.Google.ComputeEngine)
 	if claims1.Subject != claims2.AuthorizedParty {
 		return nil, &VerifyError{e: fmt.Sprintf(`JWT claims "azp" and "sub" must be equal, but got %#v and %#v`, claims2.AuthorizedParty,
 			claims1.Subject)}
@@ -263,26 +496,44 @@ func (a *InstanceIdentityVerifier) Verify(ctx context.Context, jwtString string)
 		return nil, &VerifyError{e: fmt.Sprintf(`JWT claim "email" (%#v) is not a vallid email or it illegally is not a user-managed `+
 			`service account email`, claims2.Email)}
 	}
+	// Check the allow-lists before calling any APIs, so that identities of foreign instances are rejected cheaply.
+	if len(a.allowedServiceAccounts) > 0 && !matchesAny(a.allowedServiceAccounts, claims2.Email) {
+		return nil, &VerifyError{e: fmt.Sprintf("instance has service account %#v, which is not allowed", claims2.Email)}
+	}
+	if !standardFormat {
+		if err := a.validateAllowLists(claims2); err != nil {
+			return nil, err
+		}
+	}
 
-	errChannel := make(chan error)
+	validations := 2
+	if standardFormat {
+		// A standard format token does not identify the instance, so only the service account can be validated.
+		validations = 1
+	}
+	errChannel := make(chan error, validations)
 	ctx, cancelFunc := context.WithCancel(ctx)
 	defer cancelFunc()
-	go func() {
-		err := a.validateClaims2(ctx, claims2)
-		if err != nil {
-			project := claims2.Google.ComputeEngine.ProjectID
-			zone := claims2.Google.ComputeEngine.Zone
-			instance := claims2.Google.ComputeEngine.InstanceName
-			if _, ok := err.(*VerifyError); ok {
-				err = &VerifyError{e: fmt.Sprintf("error validating JWT claims against compute engine API (instance %s/%s/%s): %v", project,
-					zone, instance, err)}
-			} else {
-				err = fmt.Errorf("error validating JWT claims against compute engine API (instance %s/%s/%s): %w", project, zone,
-					instance, err)
+	var instance *compute.Instance
+	if !standardFormat {
+		go func() {
+			var err error
+			instance, err = a.validateClaims2(ctx, claims2)
+			if err != nil {
+				project := claims2.Google.ComputeEngine.ProjectID
+				zone := claims2.Google.ComputeEngine.Zone
+				instance := claims2.Google.ComputeEngine.InstanceName
+				if _, ok := err.(*VerifyError); ok {
+					err = &VerifyError{e: fmt.Sprintf("error validating JWT claims against compute engine API (instance %s/%s/%s): %v",
+						project, zone, instance, err)}
+				} else {
+					err = fmt.Errorf("error validating JWT claims against compute engine API (instance %s/%s/%s): %w", project, zone,
+						instance, err)
+				}
 			}
-		}
-		errChannel <- err
-	}()
+			errChannel <- err
+		}()
+	}
 	go func() {
 		err := a.validateServiceAccountClaims(ctx, claims2.Email, claims1.Subject)
 		if err != nil {
@@ -295,13 +546,21 @@ func (a *InstanceIdentityVerifier) Verify(ctx context.Context, jwtString string)
 		}
 		errChannel <- err
 	}()
-	err = <-errChannel
+	// Wait for all validations, so that a JWT is only accepted if all succeed. The first error cancels the other validation.
+	err = nil
+	for i := 0; i < validations; i++ {
+		if err2 := <-errChannel; err2 != nil && err == nil {
+			err = err2
+			cancelFunc()
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &InstanceIdentity{
-		Claims1: claims1,
-		Claims2: claims2,
+		Claims1:  claims1,
+		Claims2:  claims2,
+		Instance: instance,
 	}, nil
 }
 
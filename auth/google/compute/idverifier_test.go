@@ -2,22 +2,34 @@ package compute
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
 	"github.com/jbrekelmans/go-lib/auth/google"
 	"github.com/jbrekelmans/go-lib/test"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/cloudresourcemanager/v1"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iam/v1"
 )
 
 var testAudience = "https://example.com/"
 var testInstance = &compute.Instance{
 	CreationTimestamp: "2020-05-16T15:57:44.999999999+10:00",
+	Id:                7483927914964205112,
 	Name:              "instance-1",
-	Status:            InstanceStatusRunning,
-	Zone:              "australia-southeast1-b",
+	ServiceAccounts: []*compute.ServiceAccount{
+		{Email: "198285616681-compute@developer.gserviceaccount.com"},
+	},
+	Status: InstanceStatusRunning,
+	Zone:   "australia-southeast1-b",
 }
 var testJWTToken = "eyJhbGciOiJSUzI1NiIsImtpZCI6ImMxNzcxODE0YmE2YTcwNjkzZmI5NDEyZGEzYzZlOTBjMmJmNWI5MjciLCJ0eXAiOiJKV1QifQ.eyJhdWQiOiJo" +
 	"dHRwczovL2V4YW1wbGUuY29tLyIsImF6cCI6IjExNTU4NjE3NDA5MDY2MDcxNzQ3NSIsImVtYWlsIjoiMTk4Mjg1NjE2NjgxLWNvbXB1dGVAZGV2ZWx" +
@@ -53,9 +65,6 @@ func init() {
 
 func setup(t *testing.T, opts ...InstanceIdentityVerifierOption) (ctx context.Context, i *InstanceIdentityVerifier, teardown func()) {
 	disposable := test.RedirectLogs(t)
-	timeSource := func() time.Time {
-		return testTimeNow
-	}
 	ctx, cancel := context.WithCancel(context.Background())
 	opts = append([]InstanceIdentityVerifierOption{
 		WithAllowNonUserManagedServiceAccounts(true),
@@ -66,7 +75,7 @@ func setup(t *testing.T, opts ...InstanceIdentityVerifierOption) (ctx context.Co
 		WithServiceAccountGetter(func(ctx context.Context, name string) (*iam.ServiceAccount, error) {
 			return testServiceAccount, nil
 		}),
-		WithTimeSource(timeSource),
+		WithClock(test.NewFakeClock(testTimeNow)),
 	}, opts...)
 	var err error
 	i, err = NewInstanceIdentityVerifier(testAudience, opts...)
@@ -104,3 +113,376 @@ func Test_InstanceIdentityVerifier_Verify_Success(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_InstanceIdentityVerifier_Verify_WaitsForAllValidations(t *testing.T) {
+	serviceAccountValidated := make(chan struct{})
+	releaseInstance := make(chan struct{})
+	ctx, a, teardown := setup(t,
+		WithInstanceGetter(func(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+			<-releaseInstance
+			return nil, &googleapi.Error{Code: 404}
+		}),
+		WithServiceAccountGetter(func(ctx context.Context, name string) (*iam.ServiceAccount, error) {
+			defer close(serviceAccountValidated)
+			return testServiceAccount, nil
+		}),
+	)
+	defer teardown()
+
+	errChannel := make(chan error, 1)
+	go func() {
+		_, err := a.Verify(ctx, testJWTToken)
+		errChannel <- err
+	}()
+	<-serviceAccountValidated
+	// The service account validation succeeded, but Verify must not return until the instance validation completed.
+	select {
+	case err := <-errChannel:
+		close(releaseInstance)
+		t.Fatalf("expected Verify to wait for the instance validation, but it returned %v", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+	close(releaseInstance)
+	if err := <-errChannel; err == nil {
+		t.Fatal("expected error")
+	} else if _, ok := err.(*VerifyError); !ok {
+		t.Fatalf("expected *VerifyError, but got %v", err)
+	}
+}
+
+type refreshingKeySetProvider struct {
+	refreshed bool
+}
+
+func (r *refreshingKeySetProvider) Get(ctx context.Context) (google.KeySet, error) {
+	if r.refreshed {
+		return testKeySetProvider.Get(ctx)
+	}
+	return google.KeySet{}, nil
+}
+
+func (r *refreshingKeySetProvider) Refresh(ctx context.Context, keyID string) (google.KeySet, error) {
+	r.refreshed = true
+	return r.Get(ctx)
+}
+
+func Test_InstanceIdentityVerifier_Verify_RefreshesKeySetOnUnknownKeyID(t *testing.T) {
+	keySetProvider := &refreshingKeySetProvider{}
+	ctx, a, teardown := setup(t, WithKeySetProvider(keySetProvider))
+	defer teardown()
+
+	if _, err := a.Verify(ctx, testJWTToken); err != nil {
+		t.Fatal(err)
+	}
+	if !keySetProvider.refreshed {
+		t.Fatal("expected key set to be refreshed")
+	}
+}
+
+func Test_InstanceIdentityVerifier_Verify_KeyPolicy(t *testing.T) {
+	ctx, a, teardown := setup(t, WithKeyPolicy(google.DefaultKeyPolicy()))
+	defer teardown()
+	if _, err := a.Verify(ctx, testJWTToken); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, a, teardown = setup(t, WithKeyPolicy(&google.KeyPolicy{AllowedAlgorithms: []x509.PublicKeyAlgorithm{x509.ECDSA}}))
+	defer teardown()
+	_, err := a.Verify(ctx, testJWTToken)
+	if _, ok := err.(*VerifyError); !ok {
+		t.Fatalf("expected *VerifyError, but got %v", err)
+	}
+}
+
+func Test_InstanceIdentityVerifier_Verify_AllowLists(t *testing.T) {
+	for _, c := range []struct {
+		allowed bool
+		opt     InstanceIdentityVerifierOption
+	}{
+		{true, WithAllowedProjects("other", "scratch-*")},
+		{false, WithAllowedProjects("other")},
+		{true, WithAllowedZones("australia-southeast1-b")},
+		{false, WithAllowedZones("us-*")},
+		{true, WithAllowedRegions("australia-southeast1")},
+		{false, WithAllowedRegions("australia-southeast2")},
+		{true, WithAllowedServiceAccounts("*@developer.gserviceaccount.com")},
+		{false, WithAllowedServiceAccounts("*@scratch-playground.iam.gserviceaccount.com")},
+	} {
+		apiCalled := false
+		ctx, a, teardown := setup(t, c.opt, WithInstanceGetter(func(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+			apiCalled = true
+			return testInstance, nil
+		}))
+		_, err := a.Verify(ctx, testJWTToken)
+		teardown()
+		if c.allowed && err != nil {
+			t.Fatal(err)
+		}
+		if !c.allowed {
+			if _, ok := err.(*VerifyError); !ok {
+				t.Fatalf("expected *VerifyError, but got %v", err)
+			}
+			if apiCalled {
+				t.Fatal("expected identity to be rejected before calling the compute API")
+			}
+		}
+	}
+}
+
+func Test_InstanceIdentityVerifier_Verify_InstanceConstraints(t *testing.T) {
+	instance := *testInstance
+	instance.Labels = map[string]string{"tenant": "a"}
+	instance.Metadata = &compute.Metadata{Items: []*compute.MetadataItems{{Key: "enable-oslogin"}}}
+	instance.Tags = &compute.Tags{Items: []string{"web"}}
+	for _, c := range []struct {
+		allowed bool
+		opt     InstanceIdentityVerifierOption
+	}{
+		{true, WithRequiredLabels(map[string]string{"tenant": "*"})},
+		{false, WithRequiredLabels(map[string]string{"tenant": "b"})},
+		{false, WithRequiredLabels(map[string]string{"team": "*"})},
+		{true, WithRequiredTags("web")},
+		{false, WithRequiredTags("db")},
+		{true, WithRequiredMetadataKeys("enable-oslogin")},
+		{false, WithRequiredMetadataKeys("startup-script")},
+		{true, WithInstanceAge(time.Hour, time.Hour*2)},
+		{false, WithInstanceAge(time.Hour*2, 0)},
+		{false, WithInstanceAge(0, time.Minute)},
+	} {
+		ctx, a, teardown := setup(t, c.opt, WithInstanceGetter(func(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+			return &instance, nil
+		}))
+		i, err := a.Verify(ctx, testJWTToken)
+		teardown()
+		if c.allowed {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i.Instance != &instance {
+				t.Fatal("expected instance to be exposed")
+			}
+		} else if _, ok := err.(*VerifyError); !ok {
+			t.Fatalf("expected *VerifyError, but got %v", err)
+		}
+	}
+}
+
+func Test_InstanceIdentityVerifier_Verify_ClockBehindCreationTime(t *testing.T) {
+	// Without WithInstanceAge, an instance is not rejected if the clock is behind the compute engine API's clock.
+	creationTime, err := time.Parse(time.RFC3339Nano, testInstance.CreationTimestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, a, teardown := setup(t, WithClock(test.NewFakeClock(creationTime.Add(-time.Second))), WithJWTClaimsLeeway(time.Hour*2))
+	defer teardown()
+	if _, err := a.Verify(ctx, testJWTToken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_InstanceIdentityVerifier_Verify_LookupCache(t *testing.T) {
+	clock := test.NewFakeClock(testTimeNow)
+	// The getters are called concurrently, and a lookup canceled by a failed validation can still be running during the next Verify.
+	var instanceGets, serviceAccountGets int64
+	var instanceErr error
+	ctx, a, teardown := setup(t,
+		WithClock(clock),
+		WithInstanceGetter(func(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+			atomic.AddInt64(&instanceGets, 1)
+			return testInstance, instanceErr
+		}),
+		WithLookupCache(time.Minute, time.Second*10, 100),
+		WithServiceAccountGetter(func(ctx context.Context, name string) (*iam.ServiceAccount, error) {
+			atomic.AddInt64(&serviceAccountGets, 1)
+			return testServiceAccount, nil
+		}),
+	)
+	defer teardown()
+	for i := 0; i < 2; i++ {
+		if _, err := a.Verify(ctx, testJWTToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if atomic.LoadInt64(&instanceGets) != 1 || atomic.LoadInt64(&serviceAccountGets) != 1 {
+		t.Fatalf("unexpected number of lookups %d and %d", instanceGets, serviceAccountGets)
+	}
+
+	// Definitive failures are cached for the negative time to live.
+	clock.Advance(time.Minute)
+	instanceErr = &googleapi.Error{Code: 404}
+	for i := 0; i < 2; i++ {
+		if _, err := a.Verify(ctx, testJWTToken); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if atomic.LoadInt64(&instanceGets) != 2 {
+		t.Fatalf("unexpected number of instance lookups %d", instanceGets)
+	}
+	clock.Advance(time.Second * 10)
+	instanceErr = nil
+	if _, err := a.Verify(ctx, testJWTToken); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&instanceGets) != 3 {
+		t.Fatalf("unexpected number of instance lookups %d", instanceGets)
+	}
+
+	// Rate limiting is not cached.
+	clock.Advance(time.Minute)
+	instanceErr = &googleapi.Error{Code: 429}
+	if _, err := a.Verify(ctx, testJWTToken); err == nil {
+		t.Fatal("expected error")
+	}
+	instanceErr = nil
+	if _, err := a.Verify(ctx, testJWTToken); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&instanceGets) != 5 {
+		t.Fatalf("unexpected number of instance lookups %d", instanceGets)
+	}
+}
+
+func Test_InstanceIdentityVerifier_Verify_ResultCache(t *testing.T) {
+	clock := test.NewFakeClock(testTimeNow)
+	instanceGets := 0
+	ctx, a, teardown := setup(t,
+		WithClock(clock),
+		WithInstanceGetter(func(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+			instanceGets++
+			return testInstance, nil
+		}),
+		WithResultCache(time.Minute, 100),
+	)
+	defer teardown()
+	verify := func(expectedInstanceGets int) {
+		if _, err := a.Verify(ctx, testJWTToken); err != nil {
+			t.Fatal(err)
+		}
+		if instanceGets != expectedInstanceGets {
+			t.Fatalf("expected %d instance lookups, but got %d", expectedInstanceGets, instanceGets)
+		}
+	}
+	verify(1)
+	verify(1)
+	if n := a.InvalidateInstance("scratch-playground", "australia-southeast1-b", "instance-1"); n != 1 {
+		t.Fatalf("expected 1 invalidated result, but got %d", n)
+	}
+	verify(2)
+	clock.Advance(time.Minute)
+	verify(3)
+	if _, err := a.Verify(ctx, testJWTToken+"x"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func Test_InstanceIdentityVerifier_InvalidateInstance_LookupCache(t *testing.T) {
+	var instanceGets int64
+	ctx, a, teardown := setup(t,
+		WithInstanceGetter(func(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+			atomic.AddInt64(&instanceGets, 1)
+			return testInstance, nil
+		}),
+		WithLookupCache(time.Minute, time.Second*10, 100),
+		WithResultCache(time.Minute, 100),
+	)
+	defer teardown()
+	for i := 0; i < 2; i++ {
+		if _, err := a.Verify(ctx, testJWTToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.InvalidateInstance("scratch-playground", "australia-southeast1-b", "instance-1")
+	if _, err := a.Verify(ctx, testJWTToken); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&instanceGets); n != 2 {
+		t.Fatalf("expected the instance to be looked up again after InvalidateInstance, but got %d lookups", n)
+	}
+}
+
+func Test_InstanceIdentityVerifier_Verify_InstanceIDAndProjectNumber(t *testing.T) {
+	instance := *testInstance
+	instance.Id++
+	ctx, a, teardown := setup(t, WithInstanceGetter(func(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+		return &instance, nil
+	}))
+	defer teardown()
+	if _, err := a.Verify(ctx, testJWTToken); err == nil {
+		t.Fatal("expected error because instance ID does not match")
+	}
+
+	for projectNumber, allowed := range map[int64]bool{198285616681: true, 1: false} {
+		projectNumber := projectNumber
+		ctx, a, teardown := setup(t, WithCheckProjectNumber(true), WithProjectGetter(func(ctx context.Context, projectID string) (
+			*cloudresourcemanager.Project, error) {
+			return &cloudresourcemanager.Project{ProjectId: projectID, ProjectNumber: projectNumber}, nil
+		}))
+		_, err := a.Verify(ctx, testJWTToken)
+		teardown()
+		if allowed && err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := err.(*VerifyError); !allowed && !ok {
+			t.Fatalf("expected *VerifyError, but got %v", err)
+		}
+	}
+
+	ctx, a, teardown = setup(t, WithRequiredLicenses("1000"))
+	defer teardown()
+	if _, err := a.Verify(ctx, testJWTToken); err == nil {
+		t.Fatal("expected error because license is not claimed")
+	}
+}
+
+type staticKeySetProvider google.KeySet
+
+func (s staticKeySetProvider) Get(ctx context.Context) (google.KeySet, error) {
+	return google.KeySet(s), nil
+}
+
+func Test_InstanceIdentityVerifier_Verify_StandardFormat(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(&jwt.Claims{
+		Audience: jwt.Audience{testAudience},
+		Expiry:   jwt.NewNumericDate(testTimeNow.Add(time.Minute * 10)),
+		IssuedAt: jwt.NewNumericDate(testTimeNow),
+		Issuer:   google.JWTIssuer,
+		Subject:  testServiceAccount.UniqueId,
+	}).Claims(map[string]interface{}{
+		"azp":   testServiceAccount.UniqueId,
+		"email": testServiceAccount.Email,
+	}).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySetProvider := WithKeySetProvider(staticKeySetProvider{"a": {PublicKey: &key.PublicKey}})
+	for _, c := range []struct {
+		allowed bool
+		opts    []InstanceIdentityVerifierOption
+	}{
+		{false, nil},
+		{true, []InstanceIdentityVerifierOption{WithAllowStandardFormat(true)}},
+		{false, []InstanceIdentityVerifierOption{WithAllowStandardFormat(true), WithAllowedProjects("scratch-playground")}},
+	} {
+		ctx, a, teardown := setup(t, append([]InstanceIdentityVerifierOption{keySetProvider}, c.opts...)...)
+		i, err := a.Verify(ctx, token)
+		teardown()
+		if c.allowed {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i.Instance != nil || i.Claims2.Email != testServiceAccount.Email {
+				t.Fatalf("unexpected identity %+v", i)
+			}
+		} else if _, ok := err.(*VerifyError); !ok {
+			t.Fatalf("expected *VerifyError, but got %v", err)
+		}
+	}
+}
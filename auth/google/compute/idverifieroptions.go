@@ -2,9 +2,11 @@ package compute
 
 import (
 	"fmt"
+	"path"
 	"time"
 
 	"github.com/jbrekelmans/go-lib/auth/google"
+	"github.com/jbrekelmans/go-lib/clock"
 )
 
 // InstanceIdentityVerifierOption is an option that can be passed to NewInstanceIdentityVerifier.
@@ -18,6 +20,99 @@ func WithAllowNonUserManagedServiceAccounts(v bool) InstanceIdentityVerifierOpti
 	}
 }
 
+// WithAllowedProjects returns an option for NewInstanceIdentityVerifier that restricts the projects (by ID) of instances whose identities
+// are accepted. Each of v is an exact project ID or a glob pattern (see path.Match), for example "my-project-*". Calling
+// WithAllowedProjects multiple times adds to the allowed projects. By default all projects are allowed.
+func WithAllowedProjects(v ...string) InstanceIdentityVerifierOption {
+	checkPatterns(v)
+	return func(a *InstanceIdentityVerifier) {
+		a.allowedProjects = append(a.allowedProjects, v...)
+	}
+}
+
+// WithAllowedRegions returns an option for NewInstanceIdentityVerifier that restricts the regions of instances whose identities are
+// accepted. Each of v is an exact region or a glob pattern (see path.Match), for example "australia-*". By default all regions are
+// allowed.
+func WithAllowedRegions(v ...string) InstanceIdentityVerifierOption {
+	checkPatterns(v)
+	return func(a *InstanceIdentityVerifier) {
+		a.allowedRegions = append(a.allowedRegions, v...)
+	}
+}
+
+// WithAllowedServiceAccounts returns an option for NewInstanceIdentityVerifier that restricts the service accounts (by email) of
+// instances whose identities are accepted. Each of v is an exact email or a glob pattern (see path.Match), for example
+// "*@my-project.iam.gserviceaccount.com". By default all service accounts are allowed (see also
+// WithAllowNonUserManagedServiceAccounts).
+func WithAllowedServiceAccounts(v ...string) InstanceIdentityVerifierOption {
+	checkPatterns(v)
+	return func(a *InstanceIdentityVerifier) {
+		a.allowedServiceAccounts = append(a.allowedServiceAccounts, v...)
+	}
+}
+
+// WithAllowedZones returns an option for NewInstanceIdentityVerifier that restricts the zones of instances whose identities are accepted.
+// Each of v is an exact zone or a glob pattern (see path.Match), for example "australia-southeast1-*". By default all zones are allowed.
+func WithAllowedZones(v ...string) InstanceIdentityVerifierOption {
+	checkPatterns(v)
+	return func(a *InstanceIdentityVerifier) {
+		a.allowedZones = append(a.allowedZones, v...)
+	}
+}
+
+func checkPatterns(v []string) {
+	for _, pattern := range v {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic(fmt.Errorf("v contains invalid pattern %#v: %w", pattern, err))
+		}
+	}
+}
+
+// WithAllowStandardFormat returns an option for NewInstanceIdentityVerifier that sets whether standard format tokens (tokens without the
+// "google" claim, see https://cloud.google.com/compute/docs/instances/verifying-instance-identity#token_format) are accepted. Standard
+// format tokens do not identify the instance, so only the service account is validated and InstanceIdentity.Instance is nil. If any
+// instance constraints are set (for example WithAllowedProjects or WithRequiredLabels) then standard format tokens are rejected
+// regardless. By default standard format tokens are rejected.
+func WithAllowStandardFormat(v bool) InstanceIdentityVerifierOption {
+	return func(a *InstanceIdentityVerifier) {
+		a.allowStandardFormat = v
+	}
+}
+
+// WithCheckProjectNumber returns an option for NewInstanceIdentityVerifier that sets whether the project number claimed by a JWT is
+// checked against the cloud resource manager API (see WithProjectGetter). This costs an additional API call per verification (see also
+// WithLookupCache). By default the project number is not checked. Note that the instance ID is always checked.
+func WithCheckProjectNumber(v bool) InstanceIdentityVerifierOption {
+	return func(a *InstanceIdentityVerifier) {
+		a.checkProjectNumber = v
+	}
+}
+
+// WithClock returns an option for NewInstanceIdentityVerifier that sets the clock. This is useful for unit testing, see
+// test.NewFakeClock. If no google.KeySetProvider is set (see WithKeySetProvider) then the clock is also used by the default
+// google.KeySetProvider.
+func WithClock(v clock.Clock) InstanceIdentityVerifierOption {
+	return func(a *InstanceIdentityVerifier) {
+		a.clock = v
+	}
+}
+
+// WithInstanceAge returns an option for NewInstanceIdentityVerifier that sets the minimum and maximum age of instances whose identities are
+// accepted, where the age is the period since the instance was created. If maximum is zero then there is no maximum age. By default all
+// ages are accepted.
+func WithInstanceAge(minimum, maximum time.Duration) InstanceIdentityVerifierOption {
+	if minimum < 0 {
+		panic(fmt.Errorf("minimum must be non-negative"))
+	}
+	if maximum != 0 && maximum < minimum {
+		panic(fmt.Errorf("maximum must be zero or not less than minimum"))
+	}
+	return func(a *InstanceIdentityVerifier) {
+		a.maximumInstanceAge = maximum
+		a.minimumInstanceAge = minimum
+	}
+}
+
 // WithInstanceGetter returns an option for NewInstanceIdentityVerifier that sets the compute instance getter.
 func WithInstanceGetter(v InstanceGetter) InstanceIdentityVerifierOption {
 	return func(a *InstanceIdentityVerifier) {
@@ -36,6 +131,15 @@ func WithJWTClaimsLeeway(v time.Duration) InstanceIdentityVerifierOption {
 	}
 }
 
+// WithKeyPolicy returns an option for NewInstanceIdentityVerifier that sets the policy that the key used to sign a JWT must satisfy, at the
+// time of the clock (see WithClock). For example, google.DefaultKeyPolicy() rejects keys with expired certificates, so that a stale key
+// set does not validate JWTs forever. By default no policy is enforced.
+func WithKeyPolicy(v *google.KeyPolicy) InstanceIdentityVerifierOption {
+	return func(a *InstanceIdentityVerifier) {
+		a.keyPolicy = v
+	}
+}
+
 // WithKeySetProvider returns an option for NewInstanceIdentityVerifier that sets the google.KeySetProvider.
 func WithKeySetProvider(v google.KeySetProvider) InstanceIdentityVerifierOption {
 	return func(a *InstanceIdentityVerifier) {
@@ -43,6 +147,29 @@ func WithKeySetProvider(v google.KeySetProvider) InstanceIdentityVerifierOption
 	}
 }
 
+// WithLookupCache returns an option for NewInstanceIdentityVerifier that caches the results of instance lookups (per instance) and
+// service account lookups (per unique ID) for the period timeToLive, so that instances that frequently present identities do not cause
+// an API call for each verification. Concurrent lookups of the same instance or service account are deduplicated. Lookups that fail
+// definitively (the API responds with 400 Bad Request, 404 Not Found or 410 Gone, for example because the instance does not exist) are
+// cached for the period negativeTimeToLive. Other failures, including rate limiting (429 Too Many Requests), are not cached. At most maximumSize instances and service accounts are cached, where a maximum size of 0 means unbounded.
+// Note that an instance or service account may be changed or deleted while it is cached. By default lookups are not cached.
+func WithLookupCache(timeToLive, negativeTimeToLive time.Duration, maximumSize int) InstanceIdentityVerifierOption {
+	if timeToLive < 0 {
+		panic(fmt.Errorf("timeToLive must be non-negative"))
+	}
+	if negativeTimeToLive < 0 {
+		panic(fmt.Errorf("negativeTimeToLive must be non-negative"))
+	}
+	if maximumSize < 0 {
+		panic(fmt.Errorf("maximumSize must be non-negative"))
+	}
+	return func(a *InstanceIdentityVerifier) {
+		a.lookupCacheMaximumSize = maximumSize
+		a.lookupCacheNegativeTimeToLive = negativeTimeToLive
+		a.lookupCacheTimeToLive = timeToLive
+	}
+}
+
 // WithMaximumJWTNotExpiredPeriod returns an option for NewInstanceIdentityVerifier that sets the maximum allowed period that a JWT does not expire.
 func WithMaximumJWTNotExpiredPeriod(v time.Duration) InstanceIdentityVerifierOption {
 	if v < 0 {
@@ -53,6 +180,73 @@ func WithMaximumJWTNotExpiredPeriod(v time.Duration) InstanceIdentityVerifierOpt
 	}
 }
 
+// WithProjectGetter returns an option for NewInstanceIdentityVerifier that sets the cloud resource manager project getter. See
+// WithCheckProjectNumber.
+func WithProjectGetter(v ProjectGetter) InstanceIdentityVerifierOption {
+	return func(a *InstanceIdentityVerifier) {
+		a.projectGetter = v
+	}
+}
+
+// WithRequiredLabels returns an option for NewInstanceIdentityVerifier that requires instances to have labels. Each entry of v is a
+// label key and a value that is an exact value or a glob pattern (see path.Match), where "*" requires the label to be present with any
+// value. Calling WithRequiredLabels multiple times adds to the required labels.
+func WithRequiredLabels(v map[string]string) InstanceIdentityVerifierOption {
+	for _, pattern := range v {
+		checkPatterns([]string{pattern})
+	}
+	return func(a *InstanceIdentityVerifier) {
+		if a.requiredLabels == nil {
+			a.requiredLabels = map[string]string{}
+		}
+		for key, pattern := range v {
+			a.requiredLabels[key] = pattern
+		}
+	}
+}
+
+// WithRequiredLicenses returns an option for NewInstanceIdentityVerifier that requires JWTs to claim license IDs v. The claim is only
+// present if the JWT is requested with licenses=TRUE. Calling WithRequiredLicenses multiple times adds to the required licenses.
+func WithRequiredLicenses(v ...string) InstanceIdentityVerifierOption {
+	return func(a *InstanceIdentityVerifier) {
+		a.requiredLicenses = append(a.requiredLicenses, v...)
+	}
+}
+
+// WithRequiredMetadataKeys returns an option for NewInstanceIdentityVerifier that requires instances to have metadata items with keys v.
+// Calling WithRequiredMetadataKeys multiple times adds to the required metadata keys.
+func WithRequiredMetadataKeys(v ...string) InstanceIdentityVerifierOption {
+	return func(a *InstanceIdentityVerifier) {
+		a.requiredMetadataKeys = append(a.requiredMetadataKeys, v...)
+	}
+}
+
+// WithRequiredTags returns an option for NewInstanceIdentityVerifier that requires instances to have network tags v. Calling
+// WithRequiredTags multiple times adds to the required tags.
+func WithRequiredTags(v ...string) InstanceIdentityVerifierOption {
+	return func(a *InstanceIdentityVerifier) {
+		a.requiredTags = append(a.requiredTags, v...)
+	}
+}
+
+// WithResultCache returns an option for NewInstanceIdentityVerifier that caches successful verification results, keyed by a hash of the
+// JWT, so that repeated presentations of the same JWT are verified without signature verification or API calls. A result is cached until
+// the earlier of the JWT's expiry and maximumTimeToLive after verification, which bounds the period that a cached result is used after
+// the instance or service account changes (see also InstanceIdentityVerifier.InvalidateInstance). At most maximumSize results are cached,
+// evicting the least recently used results. By default results are not cached.
+func WithResultCache(maximumTimeToLive time.Duration, maximumSize int) InstanceIdentityVerifierOption {
+	if maximumTimeToLive < 0 {
+		panic(fmt.Errorf("maximumTimeToLive must be non-negative"))
+	}
+	if maximumSize <= 0 {
+		panic(fmt.Errorf("maximumSize must be positive"))
+	}
+	return func(a *InstanceIdentityVerifier) {
+		a.resultCacheMaximumSize = maximumSize
+		a.resultCacheMaximumTimeToLive = maximumTimeToLive
+	}
+}
+
 // WithServiceAccountGetter returns an option for NewInstanceIdentityVerifier that sets the service account getter.
 func WithServiceAccountGetter(v google.ServiceAccountGetter) InstanceIdentityVerifierOption {
 	return func(a *InstanceIdentityVerifier) {
@@ -61,8 +255,7 @@ func WithServiceAccountGetter(v google.ServiceAccountGetter) InstanceIdentityVer
 }
 
 // WithTimeSource returns an option for NewInstanceIdentityVerifier that sets the time source. This is useful for unit testing.
+// WithTimeSource(v) is equivalent to WithClock(clock.Func(v)).
 func WithTimeSource(v func() time.Time) InstanceIdentityVerifierOption {
-	return func(a *InstanceIdentityVerifier) {
-		a.timeSource = v
-	}
+	return WithClock(clock.Func(v))
 }
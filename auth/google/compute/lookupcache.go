@@ -0,0 +1,66 @@
+package compute
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/jbrekelmans/go-lib/cache"
+	"github.com/jbrekelmans/go-lib/clock"
+)
+
+// instanceKey identifies an instance for the purpose of caching. See WithLookupCache.
+type instanceKey struct {
+	instance string
+	project  string
+	zone     string
+}
+
+// lookupResult is the cached result of a lookup, where err is an error that definitively determined the lookup failed (see
+// isDefinitiveLookupError).
+type lookupResult[V any] struct {
+	err   error
+	value V
+}
+
+// newCachingLookup returns a function that caches calls to lookup per key, see WithLookupCache. The cache is also returned so that
+// keys can be invalidated.
+func newCachingLookup[K comparable, V any](lookup func(ctx context.Context, key K) (V, error), clock clock.Clock,
+	timeToLive, negativeTimeToLive time.Duration, maximumSize int) (func(ctx context.Context, key K) (V, error),
+	cache.KeyedCachedEvaluator[K, lookupResult[V]]) {
+	c, _ := cache.NewExpiringKeyedCachedEvaluator(func(ctx context.Context, key K) (lookupResult[V], time.Time, error) {
+		value, err := lookup(ctx, key)
+		if err != nil {
+			if !isDefinitiveLookupError(err) {
+				// Do not cache transient errors.
+				return lookupResult[V]{}, time.Time{}, err
+			}
+			return lookupResult[V]{err: err}, clock.Now().Add(negativeTimeToLive), nil
+		}
+		return lookupResult[V]{value: value}, clock.Now().Add(timeToLive), nil
+	}, cache.WithClock(clock), cache.WithMaximumSize(maximumSize))
+	return func(ctx context.Context, key K) (V, error) {
+		result, err := c.Get(ctx, key)
+		if err != nil {
+			return result.value, err
+		}
+		return result.value, result.err
+	}, c
+}
+
+// isDefinitiveLookupError returns true if err determines that a lookup fails regardless of when it is retried, for example because the
+// instance does not exist. Other errors, including rate limiting (429) and permission errors (403), are considered transient.
+func isDefinitiveLookupError(err error) bool {
+	var googleErr *googleapi.Error
+	if !errors.As(err, &googleErr) {
+		return false
+	}
+	switch googleErr.Code {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
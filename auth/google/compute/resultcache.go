@@ -0,0 +1,86 @@
+package compute
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// verifyResult is a cached verification result, see WithResultCache.
+type verifyResult struct {
+	// expires is the earlier of the time at which the JWT expires and the maximum time to live of the result cache.
+	expires  time.Time
+	identity *InstanceIdentity
+	key      [sha256.Size]byte
+}
+
+// resultCache is a least-recently-used cache of verification results, keyed by a hash of the JWT. See WithResultCache.
+type resultCache struct {
+	elements map[[sha256.Size]byte]*list.Element
+	// lru contains *verifyResult values, the front being the most recently used.
+	lru         *list.List
+	maximumSize int
+	mutex       sync.Mutex
+}
+
+func newResultCache(maximumSize int) *resultCache {
+	return &resultCache{
+		elements:    map[[sha256.Size]byte]*list.Element{},
+		lru:         list.New(),
+		maximumSize: maximumSize,
+	}
+}
+
+// get returns the result of key if it has not expired at now. An expired result is removed.
+func (r *resultCache) get(key [sha256.Size]byte, now time.Time) (*InstanceIdentity, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	element, ok := r.elements[key]
+	if !ok {
+		return nil, false
+	}
+	result := element.Value.(*verifyResult)
+	if !now.Before(result.expires) {
+		r.removeLockedSection(element)
+		return nil, false
+	}
+	r.lru.MoveToFront(element)
+	return result.identity, true
+}
+
+// set adds result, evicting the least recently used results as needed. Expired results need not be evicted first, because they are
+// not used and so become the least recently used results.
+func (r *resultCache) set(result *verifyResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if element, ok := r.elements[result.key]; ok {
+		r.removeLockedSection(element)
+	}
+	for r.lru.Len() >= r.maximumSize {
+		r.removeLockedSection(r.lru.Back())
+	}
+	r.elements[result.key] = r.lru.PushFront(result)
+}
+
+// invalidateIf removes the results for which predicate returns true, and returns the number of removed results.
+func (r *resultCache) invalidateIf(predicate func(identity *InstanceIdentity) bool) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := 0
+	for element := r.lru.Front(); element != nil; {
+		next := element.Next()
+		if predicate(element.Value.(*verifyResult).identity) {
+			r.removeLockedSection(element)
+			n++
+		}
+		element = next
+	}
+	return n
+}
+
+// removeLockedSection must be called with r.mutex locked.
+func (r *resultCache) removeLockedSection(element *list.Element) {
+	r.lru.Remove(element)
+	delete(r.elements, element.Value.(*verifyResult).key)
+}
@@ -0,0 +1,35 @@
+package compute
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func Test_resultCache_Set_EvictsLeastRecentlyUsed(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := newResultCache(2)
+	results := make([]*verifyResult, 3)
+	for i := range results {
+		results[i] = &verifyResult{
+			expires:  now.Add(time.Minute),
+			identity: &InstanceIdentity{},
+			key:      sha256.Sum256([]byte{byte(i)}),
+		}
+	}
+	r.set(results[0])
+	r.set(results[1])
+	if _, ok := r.get(results[0].key, now); !ok {
+		t.Fatal("expected result 0 to be cached")
+	}
+	r.set(results[2])
+	if _, ok := r.get(results[1].key, now); ok {
+		t.Fatal("expected result 1 to be evicted")
+	}
+	if _, ok := r.get(results[2].key, now.Add(time.Minute)); ok {
+		t.Fatal("expected result 2 to have expired")
+	}
+	if len(r.elements) != 1 || r.lru.Len() != 1 {
+		t.Fatalf("expected only result 0 to remain, but got %d results", len(r.elements))
+	}
+}
@@ -0,0 +1,96 @@
+package google
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultKeyPolicyMinimumRSAKeySize is a common default for KeyPolicy.MinimumRSAKeySize.
+	DefaultKeyPolicyMinimumRSAKeySize = 2048
+)
+
+// KeyPolicy restricts the keys that are used to verify signatures. See KeyPolicy.Check.
+type KeyPolicy struct {
+	// AllowedAlgorithms is the set of allowed public key algorithms. If AllowedAlgorithms is empty then all algorithms are allowed.
+	AllowedAlgorithms []x509.PublicKeyAlgorithm
+	// CheckKeyUsage is whether the certificate of a key is rejected if it has a key usage extension that does not include
+	// x509.KeyUsageDigitalSignature.
+	CheckKeyUsage bool
+	// CheckValidity is whether the certificates of a key are rejected if they are expired or not yet valid.
+	CheckValidity bool
+	// MinimumRSAKeySize is the minimum size in bits of RSA keys. If MinimumRSAKeySize is zero then RSA keys of any size are allowed.
+	MinimumRSAKeySize int
+	// RequireCertificate is whether keys without certificate are rejected.
+	RequireCertificate bool
+}
+
+// DefaultKeyPolicy returns a KeyPolicy that checks certificate validity and key usage, and requires RSA keys to have at least
+// DefaultKeyPolicyMinimumRSAKeySize bits.
+func DefaultKeyPolicy() *KeyPolicy {
+	return &KeyPolicy{
+		CheckKeyUsage:     true,
+		CheckValidity:     true,
+		MinimumRSAKeySize: DefaultKeyPolicyMinimumRSAKeySize,
+	}
+}
+
+// Check returns a non-nil error if key does not satisfy p at time now.
+func (p *KeyPolicy) Check(key *Key, now time.Time) error {
+	algorithm, err := publicKeyAlgorithm(key)
+	if err != nil {
+		return err
+	}
+	if len(p.AllowedAlgorithms) > 0 {
+		allowed := false
+		for _, allowedAlgorithm := range p.AllowedAlgorithms {
+			if allowedAlgorithm == algorithm {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("key has algorithm %v, but only %v are allowed", algorithm, p.AllowedAlgorithms)
+		}
+	}
+	if rsaKey, ok := key.PublicKey.(*rsa.PublicKey); ok && p.MinimumRSAKeySize > 0 && rsaKey.N.BitLen() < p.MinimumRSAKeySize {
+		return fmt.Errorf("key is a %d bit RSA key, but at least %d bits are required", rsaKey.N.BitLen(), p.MinimumRSAKeySize)
+	}
+	certificate := key.Certificate()
+	if certificate == nil {
+		if p.RequireCertificate {
+			return fmt.Errorf("key has no certificate")
+		}
+		return nil
+	}
+	if p.CheckValidity {
+		for i, c := range key.Certificates {
+			if now.Before(c.NotBefore) {
+				return fmt.Errorf("certificate %d of key is not valid before %v", i, c.NotBefore)
+			}
+			if now.After(c.NotAfter) {
+				return fmt.Errorf("certificate %d of key expired at %v", i, c.NotAfter)
+			}
+		}
+	}
+	if p.CheckKeyUsage && certificate.KeyUsage != 0 && certificate.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return fmt.Errorf("certificate of key does not allow digital signatures")
+	}
+	return nil
+}
+
+func publicKeyAlgorithm(key *Key) (x509.PublicKeyAlgorithm, error) {
+	switch key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return x509.RSA, nil
+	case *ecdsa.PublicKey:
+		return x509.ECDSA, nil
+	case ed25519.PublicKey:
+		return x509.Ed25519, nil
+	}
+	return x509.UnknownPublicKeyAlgorithm, fmt.Errorf("key has unsupported type %T", key.PublicKey)
+}
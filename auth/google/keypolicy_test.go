@@ -0,0 +1,29 @@
+package google
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/auth"
+)
+
+func Test_KeyPolicy_Check_Validity(t *testing.T) {
+	certificate, err := auth.ParseCertificate(testCertificatePEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := NewKeyFromCertificate(certificate)
+	p := DefaultKeyPolicy()
+	if err := p.Check(key, time.Date(2020, 5, 16, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Check(key, time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Fatal("expected error because certificate is not yet valid")
+	}
+	if err := p.Check(key, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Fatal("expected error because certificate is expired")
+	}
+	if err := (&KeyPolicy{MinimumRSAKeySize: 4096}).Check(key, time.Time{}); err == nil {
+		t.Fatal("expected error because RSA key is too small")
+	}
+}
@@ -2,21 +2,71 @@ package google
 
 import (
 	"context"
+	"crypto"
 	"crypto/x509"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/jbrekelmans/go-lib/auth"
 	"github.com/jbrekelmans/go-lib/cache"
+	"github.com/jbrekelmans/go-lib/clock"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
 	// DefaultCachingKeySetProviderTimeToLive is a common default for the timeToLive parameter of CachingKeySetProvider.
 	DefaultCachingKeySetProviderTimeToLive = time.Minute * 5
+	// DefaultCachingKeySetProviderStaleIfError is a common default for the period that CachingKeySetProvider serves a stale key set if
+	// the base KeySetProvider fails. See cache.WithStaleIfError.
+	DefaultCachingKeySetProviderStaleIfError = time.Hour
+	// DefaultCachingKeySetProviderErrorBackoffInitial is a common default for the initial backoff period of CachingKeySetProvider
+	// after the base KeySetProvider fails. See cache.WithErrorBackoff.
+	DefaultCachingKeySetProviderErrorBackoffInitial = time.Second
+	// DefaultCachingKeySetProviderErrorBackoffMaximum is a common default for the maximum backoff period of CachingKeySetProvider
+	// after the base KeySetProvider fails. See cache.WithErrorBackoff.
+	DefaultCachingKeySetProviderErrorBackoffMaximum = time.Minute
+	// DefaultCachingKeySetProviderMinimumRefreshInterval is a common default for the minimum interval between refreshes of
+	// CachingKeySetProvider's key set because of unknown key identifiers. See RefreshingKeySetProvider.
+	DefaultCachingKeySetProviderMinimumRefreshInterval = time.Second * 30
+	// DefaultCachingKeySetProviderMinimumTimeToLive is a common default for the lower bound of the time to live that
+	// CachingKeySetProvider derives from the HTTP caching metadata of a key set. See WithTimeToLiveBounds.
+	DefaultCachingKeySetProviderMinimumTimeToLive = time.Minute
+	// DefaultCachingKeySetProviderMaximumTimeToLive is a common default for the upper bound of the time to live that
+	// CachingKeySetProvider derives from the HTTP caching metadata of a key set. See WithTimeToLiveBounds.
+	DefaultCachingKeySetProviderMaximumTimeToLive = time.Hour * 24
 )
 
-// KeySet contains entries where each entry represents a key identifier and certificate.
-type KeySet = map[string]*x509.Certificate
+// Key is a public key with an optional certificate chain. See KeySet.
+type Key struct {
+	// Algorithm is the JWS algorithm that the key is intended to be used with (see https://tools.ietf.org/html/rfc7517#section-4.4),
+	// or the empty string if unknown.
+	Algorithm string
+	// Certificates is the certificate chain of the key, where the first certificate contains PublicKey. Certificates is empty if the key
+	// has no certificate.
+	Certificates []*x509.Certificate
+	// PublicKey is one of *rsa.PublicKey, *ecdsa.PublicKey and ed25519.PublicKey.
+	PublicKey crypto.PublicKey
+}
+
+// NewKeyFromCertificate returns the Key of certificate.
+func NewKeyFromCertificate(certificate *x509.Certificate) *Key {
+	return &Key{
+		Certificates: []*x509.Certificate{certificate},
+		PublicKey:    certificate.PublicKey,
+	}
+}
+
+// Certificate returns the certificate that contains the key, or nil if the key has no certificate.
+func (k *Key) Certificate() *x509.Certificate {
+	if len(k.Certificates) == 0 {
+		return nil
+	}
+	return k.Certificates[0]
+}
+
+// KeySet contains entries where each entry represents a key identifier and key.
+type KeySet = map[string]*Key
 
 // KeySetProvider is an interface for getting a set of keys.
 type KeySetProvider interface {
@@ -24,6 +74,18 @@ type KeySetProvider interface {
 	Get(ctx context.Context) (KeySet, error)
 }
 
+// RefreshingKeySetProvider is a KeySetProvider that can refresh its key set on demand. This is useful when a JWT is signed with a key
+// that is not in the key set returned by Get, for example because the issuer rotated its keys and the key set is cached.
+type RefreshingKeySetProvider interface {
+	KeySetProvider
+
+	// Refresh is called because keyID is not in the key set returned by Get. Refresh returns a key set that is at least as recent as
+	// the key set returned by Get, and that is refreshed unless the key set was refreshed recently. In other words: implementations
+	// should rate-limit refreshes, because keyID is not trusted.
+	// The returned map should not be modified.
+	Refresh(ctx context.Context, keyID string) (KeySet, error)
+}
+
 type staticKeySetProvider struct {
 	keySet KeySet
 }
@@ -39,7 +101,7 @@ func StaticKeySetProvider(keySet map[string]string) (KeySetProvider, error) {
 		if err != nil {
 			return nil, fmt.Errorf("keySet[%#v] is invalid: %w", keyID, err)
 		}
-		s.keySet[keyID] = certificate
+		s.keySet[keyID] = NewKeyFromCertificate(certificate)
 	}
 	return s, nil
 }
@@ -50,52 +112,111 @@ func (s *staticKeySetProvider) Get(ctx context.Context) (KeySet, error) {
 }
 
 type cachingKeySetProvider struct {
-	base            KeySetProvider
-	cachedEvaluator cache.CachedEvaluator
-	timeToLive      time.Duration
+	base                   KeySetProvider
+	cachedEvaluator        cache.CachedEvaluator[KeySet]
+	clock                  clock.Clock
+	lastRefresh            time.Time
+	maximumTimeToLive      time.Duration
+	minimumRefreshInterval time.Duration
+	minimumTimeToLive      time.Duration
+	mutex                  sync.Mutex
+	timeToLive             time.Duration
 }
 
-type keySetWithExpires struct {
-	keySet  KeySet
-	expires time.Time
+// CachingKeySetProvider wrapss a KeySetProvider and adds caching.
+// If base implements MetadataKeySetProvider then the time to live of a key set is derived from its HTTP caching metadata (limited to
+// the bounds set by WithTimeToLiveBounds), and timeToLive is only used if the metadata does not determine a time to live.
+// The returned KeySetProvider implements RefreshingKeySetProvider, where refreshes are rate-limited as configured by
+// WithMinimumRefreshInterval. The returned KeySetProvider also implements io.Closer, which should be called if a refresher is enabled
+// (see cache.WithRefreshAhead).
+func CachingKeySetProvider(timeToLive time.Duration, base KeySetProvider, opts ...CachingKeySetProviderOption) KeySetProvider {
+	return newCachingKeySetProvider(timeToLive, base, newCachingKeySetProviderOptions(opts))
 }
 
-// CachingKeySetProvider wrapss a KeySetProvider and adds caching.
-func CachingKeySetProvider(timeToLive time.Duration, base KeySetProvider) KeySetProvider {
+func newCachingKeySetProvider(timeToLive time.Duration, base KeySetProvider, o *cachingKeySetProviderOptions) *cachingKeySetProvider {
 	c := &cachingKeySetProvider{
-		base:       base,
-		timeToLive: timeToLive,
+		base:                   base,
+		clock:                  o.clock,
+		maximumTimeToLive:      o.maximumTimeToLive,
+		minimumRefreshInterval: o.minimumRefreshInterval,
+		minimumTimeToLive:      o.minimumTimeToLive,
+		timeToLive:             timeToLive,
 	}
-	c.cachedEvaluator, _ = cache.NewCachedEvaluator(c.evaluator)
+	cacheOptions := append([]cache.Option{cache.WithClock(o.clock), cache.WithTimeToLive(timeToLive)}, o.cacheOptions...)
+	c.cachedEvaluator, _ = cache.NewExpiringCachedEvaluator(c.evaluator, cacheOptions...)
 	return c
 }
 
-func (c *cachingKeySetProvider) evaluator(ctx context.Context) (value interface{}, err error) {
-	keySet, err := c.base.Get(ctx)
-	if keySet != nil {
-		value = &keySetWithExpires{
-			keySet:  keySet,
-			expires: time.Now().Add(c.timeToLive),
-		}
+func (c *cachingKeySetProvider) evaluator(ctx context.Context) (KeySet, time.Time, error) {
+	metadataProvider, ok := c.base.(MetadataKeySetProvider)
+	if !ok {
+		keySet, err := c.base.Get(ctx)
+		return keySet, time.Time{}, err
 	}
-	return
+	keySet, metadata, err := metadataProvider.GetWithMetadata(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	now := c.clock.Now()
+	return keySet, now.Add(boundedTimeToLive(metadata, now, c.timeToLive, c.minimumTimeToLive, c.maximumTimeToLive)), nil
+}
+
+// boundedTimeToLive returns the time to live determined by metadata, limited to the bounds minimum and maximum. If metadata does not
+// determine a time to live then timeToLive is returned.
+func boundedTimeToLive(metadata *KeySetMetadata, now time.Time, timeToLive, minimum, maximum time.Duration) time.Duration {
+	t, ok := metadata.TimeToLive(now)
+	if !ok {
+		return timeToLive
+	}
+	if t < minimum {
+		return minimum
+	}
+	if t > maximum {
+		return maximum
+	}
+	return t
 }
 
 // Get implements KeySetProvider.
 func (c *cachingKeySetProvider) Get(ctx context.Context) (KeySet, error) {
-	value := c.cachedEvaluator.GetCacheOnly()
-	if value != nil {
-		valueT := value.(*keySetWithExpires)
-		if !time.Now().Before(valueT.expires) {
-			value = nil
-		}
+	return c.cachedEvaluator.Get(ctx)
+}
+
+// Refresh implements RefreshingKeySetProvider. If refreshing fails then the error is logged and the cached key set is returned.
+func (c *cachingKeySetProvider) Refresh(ctx context.Context, keyID string) (KeySet, error) {
+	keySet, err := c.cachedEvaluator.Get(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if value == nil {
-		var err error
-		value, err = c.cachedEvaluator.Evaluate(ctx)
-		if err != nil {
-			return nil, err
-		}
+	if _, ok := keySet[keyID]; ok {
+		// Another Goroutine refreshed the key set.
+		return keySet, nil
+	}
+	if !c.allowRefreshLockedSection() {
+		return keySet, nil
+	}
+	refreshedKeySet, err := c.cachedEvaluator.Evaluate(ctx)
+	if err != nil {
+		// keyID is not trusted, so a failed refresh should not turn a verification with an unknown key into a failed verification
+		// attempt.
+		log.Warnf("error refreshing key set because of unknown key identifier %#v: %v", keyID, err)
+		return keySet, nil
+	}
+	return refreshedKeySet, nil
+}
+
+func (c *cachingKeySetProvider) allowRefreshLockedSection() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	if !c.lastRefresh.IsZero() && now.Sub(c.lastRefresh) < c.minimumRefreshInterval {
+		return false
 	}
-	return value.(*keySetWithExpires).keySet, nil
+	c.lastRefresh = now
+	return true
+}
+
+// Close stops the cache's refresher. See cache.CachedEvaluator.
+func (c *cachingKeySetProvider) Close() error {
+	return c.cachedEvaluator.Close()
 }
@@ -0,0 +1,95 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/cache"
+	"github.com/jbrekelmans/go-lib/test"
+)
+
+type countingKeySetProvider struct {
+	calls int
+	err   error
+}
+
+func (c *countingKeySetProvider) Get(ctx context.Context) (KeySet, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return KeySet{}, nil
+}
+
+func Test_CachingKeySetProvider_Get_Expiry(t *testing.T) {
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	base := &countingKeySetProvider{}
+	c := CachingKeySetProvider(time.Minute, base, WithClock(fakeClock))
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		fakeClock.Advance(time.Minute - time.Nanosecond)
+	}
+	if base.calls != 2 {
+		t.Fatalf("expected base to be called twice, but it was called %d times", base.calls)
+	}
+}
+
+func Test_CachingKeySetProvider_Get_StaleIfError(t *testing.T) {
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	base := &countingKeySetProvider{}
+	c := CachingKeySetProvider(time.Minute, base, WithClock(fakeClock), WithCacheOptions(cache.WithStaleIfError(time.Hour)))
+	if _, err := c.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	base.err = fmt.Errorf("error")
+	fakeClock.Advance(time.Minute)
+	if _, err := c.Get(context.Background()); err != nil {
+		t.Fatalf("expected stale key set, but got error: %v", err)
+	}
+	fakeClock.Advance(time.Hour)
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func Test_CachingKeySetProvider_Refresh_RateLimited(t *testing.T) {
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	base := &countingKeySetProvider{}
+	c := CachingKeySetProvider(time.Hour, base, WithClock(fakeClock), WithMinimumRefreshInterval(time.Minute))
+	refresher := c.(RefreshingKeySetProvider)
+	for i := 0; i < 3; i++ {
+		if _, err := refresher.Refresh(context.Background(), "unknown"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if base.calls != 2 {
+		t.Fatalf("expected base to be called twice, but it was called %d times", base.calls)
+	}
+	fakeClock.Advance(time.Minute)
+	if _, err := refresher.Refresh(context.Background(), "unknown"); err != nil {
+		t.Fatal(err)
+	}
+	if base.calls != 3 {
+		t.Fatalf("expected base to be called 3 times, but it was called %d times", base.calls)
+	}
+}
+
+func Test_CachingKeySetProvider_Refresh_Error(t *testing.T) {
+	base := &countingKeySetProvider{}
+	c := CachingKeySetProvider(time.Hour, base)
+	if _, err := c.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	base.err = fmt.Errorf("error")
+	keySet, err := c.(RefreshingKeySetProvider).Refresh(context.Background(), "unknown")
+	if err != nil {
+		t.Fatalf("expected cached key set, but got error: %v", err)
+	}
+	if keySet == nil || base.calls != 2 {
+		t.Fatalf("unexpected key set %v or number of calls %d", keySet, base.calls)
+	}
+}
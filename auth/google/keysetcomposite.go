@@ -0,0 +1,118 @@
+package google
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+)
+
+type mergingKeySetProvider struct {
+	providers []KeySetProvider
+}
+
+// MergingKeySetProvider returns a KeySetProvider whose key set is the union of the key sets of providers. If two providers have a key
+// with the same identifier but a different public key then an error is returned, because it is ambiguous which key is authoritative.
+// If any of providers fails then an error is returned, see FailoverKeySetProvider for tolerating failures.
+// The returned KeySetProvider implements RefreshingKeySetProvider, where providers that implement RefreshingKeySetProvider are
+// refreshed.
+func MergingKeySetProvider(providers ...KeySetProvider) KeySetProvider {
+	return &mergingKeySetProvider{
+		providers: providers,
+	}
+}
+
+// Get implements KeySetProvider.
+func (m *mergingKeySetProvider) Get(ctx context.Context) (KeySet, error) {
+	return m.merge(func(provider KeySetProvider) (KeySet, error) {
+		return provider.Get(ctx)
+	})
+}
+
+// Refresh implements RefreshingKeySetProvider.
+func (m *mergingKeySetProvider) Refresh(ctx context.Context, keyID string) (KeySet, error) {
+	return m.merge(func(provider KeySetProvider) (KeySet, error) {
+		return refreshKeySet(ctx, provider, keyID)
+	})
+}
+
+func (m *mergingKeySetProvider) merge(get func(provider KeySetProvider) (KeySet, error)) (KeySet, error) {
+	merged := KeySet{}
+	for i, provider := range m.providers {
+		keySet, err := get(provider)
+		if err != nil {
+			return nil, fmt.Errorf("error getting key set of provider %d: %w", i, err)
+		}
+		for keyID, key := range keySet {
+			if mergedKey, ok := merged[keyID]; ok {
+				if !publicKeyEqual(mergedKey.PublicKey, key.PublicKey) {
+					return nil, fmt.Errorf("provider %d has a key with identifier %#v that conflicts with the key of a previous provider",
+						i, keyID)
+				}
+				continue
+			}
+			merged[keyID] = key
+		}
+	}
+	return merged, nil
+}
+
+type failoverKeySetProvider struct {
+	providers []KeySetProvider
+}
+
+// FailoverKeySetProvider returns a KeySetProvider that returns the key set of the first of providers that does not fail. For example,
+// FailoverKeySetProvider(HTTPSKeySetProvider(nil), StaticKeySetProvider(...)) falls back to a bundled key set if Google's Key Set
+// endpoint is unreachable. If all providers fail then an error is returned that wraps all errors.
+// The returned KeySetProvider implements RefreshingKeySetProvider, where providers that implement RefreshingKeySetProvider are
+// refreshed.
+func FailoverKeySetProvider(providers ...KeySetProvider) KeySetProvider {
+	return &failoverKeySetProvider{
+		providers: providers,
+	}
+}
+
+// Get implements KeySetProvider.
+func (f *failoverKeySetProvider) Get(ctx context.Context) (KeySet, error) {
+	return f.failover(ctx, func(provider KeySetProvider) (KeySet, error) {
+		return provider.Get(ctx)
+	})
+}
+
+// Refresh implements RefreshingKeySetProvider.
+func (f *failoverKeySetProvider) Refresh(ctx context.Context, keyID string) (KeySet, error) {
+	return f.failover(ctx, func(provider KeySetProvider) (KeySet, error) {
+		return refreshKeySet(ctx, provider, keyID)
+	})
+}
+
+func (f *failoverKeySetProvider) failover(ctx context.Context, get func(provider KeySetProvider) (KeySet, error)) (KeySet, error) {
+	var errs []error
+	for i, provider := range f.providers {
+		keySet, err := get(provider)
+		if err == nil {
+			return keySet, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		errs = append(errs, fmt.Errorf("error getting key set of provider %d: %w", i, err))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no providers")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// refreshKeySet refreshes the key set of provider if it implements RefreshingKeySetProvider, and otherwise gets the key set.
+func refreshKeySet(ctx context.Context, provider KeySetProvider, keyID string) (KeySet, error) {
+	if refresher, ok := provider.(RefreshingKeySetProvider); ok {
+		return refresher.Refresh(ctx, keyID)
+	}
+	return provider.Get(ctx)
+}
+
+func publicKeyEqual(x, y crypto.PublicKey) bool {
+	xEqualer, ok := x.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && xEqualer.Equal(y)
+}
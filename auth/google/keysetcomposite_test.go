@@ -0,0 +1,60 @@
+package google
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+)
+
+type errorKeySetProvider struct {
+	err error
+}
+
+func (e *errorKeySetProvider) Get(ctx context.Context) (KeySet, error) {
+	return nil, e.err
+}
+
+func Test_MergingKeySetProvider_Get(t *testing.T) {
+	static, err := StaticKeySetProvider(map[string]string{testKeyID: testCertificatePEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &staticKeySetProvider{keySet: KeySet{"other": {PublicKey: &rsaKey.PublicKey}}}
+	keySet, err := MergingKeySetProvider(static, other, static).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keySet) != 2 || keySet[testKeyID] == nil || keySet["other"] == nil {
+		t.Fatalf("unexpected key set %v", keySet)
+	}
+	conflicting := &staticKeySetProvider{keySet: KeySet{testKeyID: {PublicKey: &rsaKey.PublicKey}}}
+	if _, err := MergingKeySetProvider(static, conflicting).Get(context.Background()); err == nil {
+		t.Fatal("expected error because of conflicting keys")
+	}
+}
+
+func Test_FailoverKeySetProvider_Get(t *testing.T) {
+	static, err := StaticKeySetProvider(map[string]string{testKeyID: testCertificatePEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err1 := errors.New("error 1")
+	keySet, err := FailoverKeySetProvider(&errorKeySetProvider{err: err1}, static).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keySet[testKeyID] == nil {
+		t.Fatalf("unexpected key set %v", keySet)
+	}
+	err2 := errors.New("error 2")
+	_, err = FailoverKeySetProvider(&errorKeySetProvider{err: err1}, &errorKeySetProvider{err: err2}).Get(context.Background())
+	if !errors.Is(err, err1) || !errors.Is(err, err2) {
+		t.Fatalf("expected error wrapping both errors, but got %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package google
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jbrekelmans/go-lib/clock"
+)
+
+const (
+	// DefaultFileKeySetProviderPollInterval is a common default for the interval at which FileKeySetProvider checks its file for
+	// changes. See WithFilePollInterval.
+	DefaultFileKeySetProviderPollInterval = time.Second * 10
+)
+
+type fileKeySetProvider struct {
+	clock        clock.Clock
+	closed       bool
+	errorHandler func(err error)
+	// hash is the SHA-256 hash of the file contents that keySet or lastError was derived from.
+	hash [sha256.Size]byte
+	// keySet is the last key set that was successfully loaded.
+	keySet atomic.Pointer[KeySet]
+	// lastError is the error of the last load, or nil if the last load succeeded.
+	lastError    atomic.Pointer[error]
+	modTime      time.Time
+	mutex        sync.Mutex
+	path         string
+	pollInterval time.Duration
+	pollTimer    clock.Timer
+	size         int64
+}
+
+// FileKeySetProvider gets keys from the file at path, which is either a JSON object where each entry represents a key identifier and PEM
+// encoded X509 certificate (the format of KeySetURL) or a JSON Web Key Set (see JWKSKeySetProvider). This is useful for key sets mounted
+// from secrets.
+// The file is loaded by FileKeySetProvider, and is then checked for changes (by modification time, size and hash) at the interval set by
+// WithFilePollInterval. If a change is detected then the key set is reloaded and atomically swapped. If a (re)load fails then the error
+// is reported via the handler set by WithFileErrorHandler and the last successfully loaded key set is kept. Get only returns an error if
+// no key set was ever loaded successfully.
+// The returned KeySetProvider implements io.Closer, which stops polling.
+func FileKeySetProvider(path string, opts ...FileKeySetProviderOption) KeySetProvider {
+	o := &fileKeySetProviderOptions{
+		clock: clock.System,
+		errorHandler: func(err error) {
+			log.Errorf("error loading key set file: %v", err)
+		},
+		pollInterval: DefaultFileKeySetProviderPollInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	f := &fileKeySetProvider{
+		clock:        o.clock,
+		errorHandler: o.errorHandler,
+		path:         path,
+		pollInterval: o.pollInterval,
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.loadLockedSection()
+	f.schedulePollLockedSection()
+	return f
+}
+
+func (f *fileKeySetProvider) poll() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return
+	}
+	f.loadLockedSection()
+	f.schedulePollLockedSection()
+}
+
+func (f *fileKeySetProvider) schedulePollLockedSection() {
+	if f.pollInterval > 0 {
+		f.pollTimer = f.clock.AfterFunc(f.pollInterval, f.poll)
+	}
+}
+
+// loadLockedSection loads the key set if the file changed since the last load.
+func (f *fileKeySetProvider) loadLockedSection() {
+	fileInfo, err := os.Stat(f.path)
+	if err != nil {
+		f.setErrorLockedSection(fmt.Errorf("error getting info of file %#v: %w", f.path, err))
+		return
+	}
+	if f.keySet.Load() != nil && fileInfo.ModTime().Equal(f.modTime) && fileInfo.Size() == f.size {
+		return
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		f.setErrorLockedSection(fmt.Errorf("error reading file %#v: %w", f.path, err))
+		return
+	}
+	hash := sha256.Sum256(data)
+	f.modTime = fileInfo.ModTime()
+	f.size = fileInfo.Size()
+	if hash == f.hash && (f.keySet.Load() != nil || f.lastError.Load() != nil) {
+		return
+	}
+	f.hash = hash
+	keySet, err := parseKeySetJSON(data)
+	if err != nil {
+		f.setErrorLockedSection(fmt.Errorf("error parsing file %#v: %w", f.path, err))
+		return
+	}
+	f.keySet.Store(&keySet)
+	f.lastError.Store(nil)
+}
+
+func (f *fileKeySetProvider) setErrorLockedSection(err error) {
+	f.lastError.Store(&err)
+	f.errorHandler(err)
+}
+
+// parseKeySetJSON parses a JSON Web Key Set or a JSON object where each entry represents a key identifier and PEM encoded X509
+// certificate.
+func parseKeySetJSON(data []byte) (KeySet, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("unexpected JSON: %w", err)
+	}
+	if keys, ok := object["keys"]; ok && bytes.HasPrefix(bytes.TrimSpace(keys), []byte("[")) {
+		return parseJWKS(bytes.NewReader(data))
+	}
+	return parsePEMKeySet(bytes.NewReader(data))
+}
+
+// Get implements KeySetProvider.
+func (f *fileKeySetProvider) Get(ctx context.Context) (KeySet, error) {
+	if keySet := f.keySet.Load(); keySet != nil {
+		return *keySet, nil
+	}
+	if err := f.lastError.Load(); err != nil {
+		return nil, *err
+	}
+	return nil, fmt.Errorf("file %#v has not been loaded", f.path)
+}
+
+// Close stops polling.
+func (f *fileKeySetProvider) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	if f.pollTimer != nil {
+		f.pollTimer.Stop()
+	}
+	return nil
+}
+
+type snapshotKeySetProvider struct {
+	base KeySetProvider
+	// last is the last snapshot that was written.
+	last []byte
+	// lastKeySet is the key set of last.
+	lastKeySet KeySet
+	mutex      sync.Mutex
+	path       string
+}
+
+// SnapshotKeySetProvider wraps a KeySetProvider and writes each key set that base successfully returns to the file at path (as a JSON
+// Web Key Set), so that the last known key set is available for a cold start when base is unavailable. For example:
+//
+//	FailoverKeySetProvider(SnapshotKeySetProvider(HTTPSKeySetProvider(nil), path), FileKeySetProvider(path))
+//
+// The file is replaced atomically, and is only written if the key set changed. Errors writing the file are logged and do not cause Get to
+// fail. A key set is only encoded if base returns a different map than the last time, so wrapping a caching KeySetProvider (such as
+// CachingKeySetProvider) is cheap.
+// The returned KeySetProvider implements RefreshingKeySetProvider if base does.
+func SnapshotKeySetProvider(base KeySetProvider, path string) KeySetProvider {
+	s := &snapshotKeySetProvider{
+		base: base,
+		path: path,
+	}
+	if _, ok := base.(RefreshingKeySetProvider); ok {
+		return &refreshingSnapshotKeySetProvider{s}
+	}
+	return s
+}
+
+type refreshingSnapshotKeySetProvider struct {
+	*snapshotKeySetProvider
+}
+
+// Refresh implements RefreshingKeySetProvider.
+func (r *refreshingSnapshotKeySetProvider) Refresh(ctx context.Context, keyID string) (KeySet, error) {
+	keySet, err := r.base.(RefreshingKeySetProvider).Refresh(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	r.snapshot(keySet)
+	return keySet, nil
+}
+
+// Get implements KeySetProvider.
+func (s *snapshotKeySetProvider) Get(ctx context.Context) (KeySet, error) {
+	keySet, err := s.base.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot(keySet)
+	return keySet, nil
+}
+
+func (s *snapshotKeySetProvider) snapshot(keySet KeySet) {
+	if err := s.snapshotLockedSection(keySet); err != nil {
+		log.Warnf("error writing key set snapshot file %#v: %v", s.path, err)
+	}
+}
+
+func (s *snapshotKeySetProvider) snapshotLockedSection(keySet KeySet) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.lastKeySet != nil && reflect.ValueOf(keySet).Pointer() == reflect.ValueOf(s.lastKeySet).Pointer() {
+		return nil
+	}
+	jwks := jose.JSONWebKeySet{}
+	for keyID, key := range keySet {
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{
+			Algorithm:    key.Algorithm,
+			Certificates: key.Certificates,
+			Key:          key.PublicKey,
+			KeyID:        keyID,
+			Use:          "sig",
+		})
+	}
+	// Sort so that equal key sets have equal snapshots.
+	sortJSONWebKeys(jwks.Keys)
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, s.last) {
+		if err := writeFileAtomically(s.path, data); err != nil {
+			return err
+		}
+		s.last = data
+	}
+	s.lastKeySet = keySet
+	return nil
+}
+
+func sortJSONWebKeys(keys []jose.JSONWebKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].KeyID < keys[j].KeyID
+	})
+}
+
+// writeFileAtomically writes data to a temporary file in the directory of path and then renames the temporary file to path, so that
+// readers of path never observe a partially written file.
+func writeFileAtomically(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return err
+}
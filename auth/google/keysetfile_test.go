@@ -0,0 +1,90 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/test"
+)
+
+func writeTestFile(t *testing.T, path string, data string, modTime time.Time) {
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_FileKeySetProvider_Get_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyset.json")
+	data, err := json.Marshal(map[string]string{testKeyID: testCertificatePEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 5, 16, 0, 0, 0, 0, time.UTC)
+	writeTestFile(t, path, string(data), modTime)
+	clock := test.NewFakeClock(modTime)
+	var errs []error
+	f := FileKeySetProvider(path, WithFileClock(clock), WithFileErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	defer f.(interface{ Close() error }).Close()
+	keySet1, err := f.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keySet1[testKeyID] == nil || len(keySet1) != 1 {
+		t.Fatalf("unexpected key set %v", keySet1)
+	}
+
+	// The last good key set is kept if the file cannot be parsed.
+	writeTestFile(t, path, "{", modTime.Add(time.Second))
+	clock.Advance(DefaultFileKeySetProviderPollInterval)
+	clock.Wait()
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, but got %v", errs)
+	}
+	keySet2, err := f.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keySet2[testKeyID] != keySet1[testKeyID] {
+		t.Fatal("expected last good key set")
+	}
+
+	// The file is reloaded as a JWKS.
+	writeTestFile(t, path, `{"keys":[]}`, modTime.Add(time.Second*2))
+	clock.Advance(DefaultFileKeySetProviderPollInterval)
+	clock.Wait()
+	keySet3, err := f.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keySet3) != 0 || len(errs) != 1 {
+		t.Fatalf("unexpected key set %v or errors %v", keySet3, errs)
+	}
+}
+
+func Test_SnapshotKeySetProvider_Get(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	static, err := StaticKeySetProvider(map[string]string{testKeyID: testCertificatePEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SnapshotKeySetProvider(static, path).Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	f := FileKeySetProvider(path, WithFilePollInterval(0))
+	keySet, err := f.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := keySet[testKeyID]; key == nil || key.Certificate() == nil || len(keySet) != 1 {
+		t.Fatalf("unexpected key set %v", keySet)
+	}
+}
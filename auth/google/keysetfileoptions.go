@@ -0,0 +1,44 @@
+package google
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/clock"
+)
+
+// FileKeySetProviderOption is an option that can be passed to FileKeySetProvider.
+type FileKeySetProviderOption = func(o *fileKeySetProviderOptions)
+
+type fileKeySetProviderOptions struct {
+	clock        clock.Clock
+	errorHandler func(err error)
+	pollInterval time.Duration
+}
+
+// WithFileClock returns an option for FileKeySetProvider that sets the clock used for polling. This is useful for unit testing, see
+// test.NewFakeClock.
+func WithFileClock(v clock.Clock) FileKeySetProviderOption {
+	return func(o *fileKeySetProviderOptions) {
+		o.clock = v
+	}
+}
+
+// WithFileErrorHandler returns an option for FileKeySetProvider that sets the function that is called when the file cannot be loaded.
+// The default handler logs the error.
+func WithFileErrorHandler(v func(err error)) FileKeySetProviderOption {
+	return func(o *fileKeySetProviderOptions) {
+		o.errorHandler = v
+	}
+}
+
+// WithFilePollInterval returns an option for FileKeySetProvider that sets the interval at which the file is checked for changes. If v is
+// zero then the file is only loaded once. The default is DefaultFileKeySetProviderPollInterval.
+func WithFilePollInterval(v time.Duration) FileKeySetProviderOption {
+	if v < 0 {
+		panic(fmt.Errorf("v must be non-negative"))
+	}
+	return func(o *fileKeySetProviderOptions) {
+		o.pollInterval = v
+	}
+}
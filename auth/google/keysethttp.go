@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
 
 	"github.com/hashicorp/go-cleanhttp"
 	"google.golang.org/api/googleapi"
@@ -19,46 +21,117 @@ const (
 
 type httpsKeySetProvider struct {
 	httpClient *http.Client
+	// last is the last key set that was successfully fetched and is used to make conditional requests.
+	last  *httpsKeySetProviderResponse
+	mutex sync.Mutex
+	// parse parses the response body of a GET request to url.
+	parse func(body io.Reader) (KeySet, error)
+	url   string
+}
+
+type httpsKeySetProviderResponse struct {
+	keySet   KeySet
+	metadata *KeySetMetadata
 }
 
 // HTTPSKeySetProvider gets keys from Google's Key Set endpoint (see KeySetURL).
+// The returned KeySetProvider implements MetadataKeySetProvider. The returned KeySetProvider makes conditional requests (using the ETag
+// and Last-Modified headers of the last response) and reuses the last key set if the key set is not modified.
 func HTTPSKeySetProvider(httpClient *http.Client) KeySetProvider {
+	return newHTTPSKeySetProvider(httpClient, KeySetURL, parsePEMKeySet)
+}
+
+func newHTTPSKeySetProvider(httpClient *http.Client, url string, parse func(body io.Reader) (KeySet, error)) *httpsKeySetProvider {
 	if httpClient == nil {
 		httpClient = cleanhttp.DefaultClient()
 	}
 	h := &httpsKeySetProvider{
 		httpClient: httpClient,
+		parse:      parse,
+		url:        url,
 	}
 	return h
 }
 
+// parsePEMKeySet parses a JSON object where each entry represents a key identifier and PEM encoded X509 certificate.
+func parsePEMKeySet(body io.Reader) (KeySet, error) {
+	keySetRaw := map[string]string{}
+	if err := json.NewDecoder(body).Decode(&keySetRaw); err != nil {
+		return nil, fmt.Errorf("unexpected JSON: %w", err)
+	}
+	keySet := KeySet{}
+	for keyID, certificatePEMString := range keySetRaw {
+		certificate, err := auth.ParseCertificate(certificatePEMString)
+		if err != nil {
+			return nil, fmt.Errorf("JSON object has an entry with key %#v that has a string value, but no PEM X509 certificate could be "+
+				"parsed from the value: %w", keyID, err)
+		}
+		keySet[keyID] = NewKeyFromCertificate(certificate)
+	}
+	return keySet, nil
+}
+
 // Get implements KeySetProvider.
 func (h *httpsKeySetProvider) Get(ctx context.Context) (KeySet, error) {
-	url := KeySetURL
+	keySet, _, err := h.GetWithMetadata(ctx)
+	return keySet, err
+}
+
+// GetWithMetadata implements MetadataKeySetProvider.
+func (h *httpsKeySetProvider) GetWithMetadata(ctx context.Context) (KeySet, *KeySetMetadata, error) {
+	url := h.url
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request GET %s: %w", url, err)
+		return nil, nil, fmt.Errorf("error creating request GET %s: %w", url, err)
+	}
+	last := h.getLast()
+	if last != nil {
+		if last.metadata.ETag != "" {
+			req.Header.Set("If-None-Match", last.metadata.ETag)
+		}
+		if last.metadata.LastModified != "" {
+			req.Header.Set("If-Modified-Since", last.metadata.LastModified)
+		}
 	}
 	res, err := h.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error doing GET %s: %w", url, err)
+		return nil, nil, fmt.Errorf("error doing GET %s: %w", url, err)
 	}
 	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified && last != nil {
+		metadata := NewKeySetMetadata(res.Header)
+		if metadata.ETag == "" {
+			metadata.ETag = last.metadata.ETag
+		}
+		if metadata.LastModified == "" {
+			metadata.LastModified = last.metadata.LastModified
+		}
+		h.setLast(last.keySet, metadata)
+		return last.keySet, metadata, nil
+	}
 	if err := googleapi.CheckResponse(res); err != nil {
-		return nil, fmt.Errorf("GET %s gave unexpected response: %w", url, err)
+		return nil, nil, fmt.Errorf("GET %s gave unexpected response: %w", url, err)
 	}
-	keySetRaw := map[string]string{}
-	if err := json.NewDecoder(res.Body).Decode(&keySetRaw); err != nil {
-		return nil, fmt.Errorf("GET %s gave response with unexpected JSON: %w", url, err)
+	keySet, err := h.parse(res.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GET %s gave response with invalid key set: %w", url, err)
 	}
-	keySet := KeySet{}
-	for keyID, certificatePEMString := range keySetRaw {
-		certificate, err := auth.ParseCertificate(certificatePEMString)
-		if err != nil {
-			return nil, fmt.Errorf("GET %s's response body is a JSON object with an entry with key %#v that has a string value, but no PEM "+
-				"X509 certificate could be parsed from the value: %w", url, keyID, err)
-		}
-		keySet[keyID] = certificate
+	metadata := NewKeySetMetadata(res.Header)
+	h.setLast(keySet, metadata)
+	return keySet, metadata, nil
+}
+
+func (h *httpsKeySetProvider) getLast() *httpsKeySetProviderResponse {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.last
+}
+
+func (h *httpsKeySetProvider) setLast(keySet KeySet, metadata *KeySetMetadata) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.last = &httpsKeySetProviderResponse{
+		keySet:   keySet,
+		metadata: metadata,
 	}
-	return keySet, nil
 }
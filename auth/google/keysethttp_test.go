@@ -0,0 +1,110 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/test"
+)
+
+const testKeyID = "c1771814ba6a70693fb9412da3c6e90c2bf5b927"
+
+var testCertificatePEM = "-----BEGIN CERTIFICATE-----\nMIIDJjCCAg6gAwIBAgIINA9D6ntD6UwwDQYJKoZIhvcNAQEFBQAwNjE0MDIGA1UE\nAxMrZmVkZXJhdGVkLXNpZ25vbi5zeXN0ZW0uZ3NlcnZpY2VhY2NvdW50LmNvbTAe\nFw0yMDA1MDgwNDI5MzJaFw0yMDA1MjQxNjQ0MzJaMDYxNDAyBgNVBAMTK2ZlZGVy\nYXRlZC1zaWdub24uc3lzdGVtLmdzZXJ2aWNlYWNjb3VudC5jb20wggEiMA0GCSqG\nSIb3DQEBAQUAA4IBDwAwggEKAoIBAQDEcofKwYd9lvL3ay0DILheSnu3YhvpMSFr\nUbXVTAaCau/umCmMoEmQ7Ve2+9PYvekTKWFwqEuA7x/HlH6spx57Nn9ilPK5PW8c\nexZgnF6hxXmbRXvT82+B/KyXqVL+B299Prx0w2TUQvxsiT26IIwii1WlyrgUh4gP\nvkN6d2r+hO5c5lV4KLWvyrSp4xY3ucVkQkKfHNrI05MTv54LwVExGK757e062Su6\nBrcLPraeSSsa1DIBpC1Se2sNNDGMTZM2EG9YFYNU5+8b64J7YmSF8MLsJmUTq2kG\nj5WTIgYZmNHmoGVhMrHpkmNZ5ALXeWnB3tYHW8q0FIoYfa8q4FutAgMBAAGjODA2\nMAwGA1UdEwEB/wQCMAAwDgYDVR0PAQH/BAQDAgeAMBYGA1UdJQEB/wQMMAoGCCsG\nAQUFBwMCMA0GCSqGSIb3DQEBBQUAA4IBAQCDmHmX0May2yvcY/YEKMZIleBzIJrZ\nIs2COueb5KwUy13aORB2vCsIA6xZh9onhOlDaf7Hd5ZziMQsn4+mo1ta3nxKInXC\nYvf3YnNOThTEgZY3ZOfI5wDs4sGVEkiF+VHdMOj4AFrB2Fapyh2NwyiSiXR+yFcW\nishQj9Lh9h1dBdz2C3ZcVzP0f9Fjfqj27N6h5PA7ooBSgXmXR2zCbT5n9+LykT3G\nyMGS0j7XL+EmO8LiLAbxW6Zxyvjd6NFD3VA2+FtgT+rVzOIIiDTDttStC3PqhbwT\n87QGg8tCjnYVAuXPrBWfoxPBNUAAWSgVdh1gsJ7sehDEofBiKJ5oU9cH\n-----END CERTIFICATE-----\n"
+
+func newTestKeySetServer(t *testing.T, handler func(w http.ResponseWriter, req *http.Request) bool) (server *httptest.Server, requests *int) {
+	requests = new(int)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*requests++
+		if handler != nil && !handler(w, req) {
+			return
+		}
+		if err := json.NewEncoder(w).Encode(map[string]string{testKeyID: testCertificatePEM}); err != nil {
+			t.Error(err)
+		}
+	}))
+	return
+}
+
+func Test_HTTPSKeySetProvider_GetWithMetadata(t *testing.T) {
+	server, _ := newTestKeySetServer(t, func(w http.ResponseWriter, req *http.Request) bool {
+		w.Header().Set("Age", "100")
+		w.Header().Set("Cache-Control", "public, max-age=19951, must-revalidate, no-transform")
+		w.Header().Set("ETag", `"abc"`)
+		return true
+	})
+	defer server.Close()
+	h := HTTPSKeySetProvider(server.Client()).(*httpsKeySetProvider)
+	h.url = server.URL
+	keySet, metadata, err := h.GetWithMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keySet[testKeyID]; !ok || len(keySet) != 1 {
+		t.Fatalf("unexpected key set %v", keySet)
+	}
+	if metadata.ETag != `"abc"` {
+		t.Fatalf("unexpected ETag %#v", metadata.ETag)
+	}
+	if timeToLive, ok := metadata.TimeToLive(time.Now()); !ok || timeToLive != time.Second*19851 {
+		t.Fatalf("unexpected time to live %v", timeToLive)
+	}
+}
+
+func Test_CachingKeySetProvider_Get_TimeToLiveFromMetadata(t *testing.T) {
+	server, requests := newTestKeySetServer(t, func(w http.ResponseWriter, req *http.Request) bool {
+		w.Header().Set("Cache-Control", "max-age=7200")
+		return true
+	})
+	defer server.Close()
+	h := HTTPSKeySetProvider(server.Client()).(*httpsKeySetProvider)
+	h.url = server.URL
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	c := CachingKeySetProvider(time.Minute, h, WithClock(fakeClock), WithTimeToLiveBounds(time.Minute, time.Hour))
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		fakeClock.Advance(time.Minute * 59)
+	}
+	// The time to live (2 hours) is limited to 1 hour.
+	if *requests != 2 {
+		t.Fatalf("expected 2 requests, but got %d", *requests)
+	}
+}
+
+func Test_HTTPSKeySetProvider_Get_ConditionalRequest(t *testing.T) {
+	var ifNoneMatch []string
+	server, requests := newTestKeySetServer(t, func(w http.ResponseWriter, req *http.Request) bool {
+		ifNoneMatch = append(ifNoneMatch, req.Header.Get("If-None-Match"))
+		if req.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+		w.Header().Set("ETag", `"abc"`)
+		return true
+	})
+	defer server.Close()
+	h := HTTPSKeySetProvider(server.Client()).(*httpsKeySetProvider)
+	h.url = server.URL
+	keySet1, err := h.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySet2, metadata, err := h.GetWithMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *requests != 2 || ifNoneMatch[0] != "" || ifNoneMatch[1] != `"abc"` {
+		t.Fatalf("unexpected requests (If-None-Match headers: %#v)", ifNoneMatch)
+	}
+	if keySet2[testKeyID] != keySet1[testKeyID] {
+		t.Fatal("expected key set to be reused")
+	}
+	if metadata.ETag != `"abc"` {
+		t.Fatalf("unexpected ETag %#v", metadata.ETag)
+	}
+}
@@ -0,0 +1,64 @@
+package google
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-jose/go-jose/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// JWKSURL is the URL of Google's Key Set in JWKS format. See https://tools.ietf.org/html/rfc7517#section-5.
+	JWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+// JWKSKeySetProvider gets keys from a JSON Web Key Set (see https://tools.ietf.org/html/rfc7517#section-5) at url, for example JWKSURL.
+// RSA, EC and OKP (Ed25519) public keys are supported, with or without a certificate chain (the "x5c" parameter). Keys that are not
+// public keys, have no key identifier, or are not used for signatures are ignored. Keys of unsupported types are ignored, as
+// recommended by https://tools.ietf.org/html/rfc7517#section-5.
+// The returned KeySetProvider implements MetadataKeySetProvider and makes conditional requests, see HTTPSKeySetProvider.
+func JWKSKeySetProvider(httpClient *http.Client, url string) KeySetProvider {
+	return newHTTPSKeySetProvider(httpClient, url, parseJWKS)
+}
+
+func parseJWKS(body io.Reader) (KeySet, error) {
+	var keySetRaw struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(body).Decode(&keySetRaw); err != nil {
+		return nil, fmt.Errorf("unexpected JSON: %w", err)
+	}
+	if keySetRaw.Keys == nil {
+		return nil, fmt.Errorf(`unexpected JSON: the JSON object does not have a required entry with key "keys"`)
+	}
+	return parseJWKs(keySetRaw.Keys)
+}
+
+// parseJWKs parses the keys of a JSON Web Key Set.
+func parseJWKs(keysRaw []json.RawMessage) (KeySet, error) {
+	keySet := KeySet{}
+	for i, keyRaw := range keysRaw {
+		var jwk jose.JSONWebKey
+		if err := jwk.UnmarshalJSON(keyRaw); err != nil {
+			log.Debugf("ignoring key at index %d of JWKS: %v", i, err)
+			continue
+		}
+		if jwk.KeyID == "" || !jwk.IsPublic() || (jwk.Use != "" && jwk.Use != "sig") {
+			log.Debugf("ignoring key at index %d of JWKS because it has no key identifier, is not a public key or is not used for "+
+				"signatures", i)
+			continue
+		}
+		if _, ok := keySet[jwk.KeyID]; ok {
+			return nil, fmt.Errorf("multiple keys have identifier %#v", jwk.KeyID)
+		}
+		keySet[jwk.KeyID] = &Key{
+			Algorithm:    jwk.Algorithm,
+			Certificates: jwk.Certificates,
+			PublicKey:    jwk.Key,
+		}
+	}
+	return keySet, nil
+}
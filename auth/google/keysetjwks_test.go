@@ -0,0 +1,57 @@
+package google
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+func Test_JWKSKeySetProvider_Get(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{Algorithm: "RS256", Key: &rsaKey.PublicKey, KeyID: "rsa", Use: "sig"},
+			{Algorithm: "ES256", Key: &ecKey.PublicKey, KeyID: "ec"},
+			{Key: &rsaKey.PublicKey, KeyID: "enc", Use: "enc"},
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := json.Marshal(jwks)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// Unsupported key types must be ignored.
+		data = append(data[:len(data)-2], []byte(`,{"kty":"oct","kid":"secret","k":"AAAA"},{"kty":"unknown"}]}`)...)
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	keySet, err := JWKSKeySetProvider(server.Client(), server.URL).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keySet) != 2 {
+		t.Fatalf("unexpected key set %v", keySet)
+	}
+	if key := keySet["rsa"]; key == nil || key.Algorithm != "RS256" || !rsaKey.PublicKey.Equal(key.PublicKey) || key.Certificate() != nil {
+		t.Fatalf("unexpected key %v", key)
+	}
+	if key := keySet["ec"]; key == nil || key.Algorithm != "ES256" || !ecKey.PublicKey.Equal(key.PublicKey) {
+		t.Fatalf("unexpected key %v", key)
+	}
+}
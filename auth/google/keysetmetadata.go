@@ -0,0 +1,97 @@
+package google
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	jasperhttp "github.com/jbrekelmans/go-lib/http"
+)
+
+// KeySetMetadata contains HTTP caching metadata of a key set. See MetadataKeySetProvider.
+type KeySetMetadata struct {
+	// Age is the value of the Age header, or 0 if the header is absent.
+	Age time.Duration
+	// CacheControl contains the Cache-Control directives, or nil if the Cache-Control headers are absent or invalid.
+	CacheControl *jasperhttp.CacheControl
+	// Date is the value of the Date header, or the zero time if the header is absent or invalid.
+	Date time.Time
+	// ETag is the value of the ETag header, or the empty string if the header is absent.
+	ETag string
+	// Expires is the value of the Expires header, or the zero time if the header is absent. An invalid value (such as "0") is
+	// represented as a time in the past, as per https://tools.ietf.org/html/rfc7234#section-5.3.
+	Expires time.Time
+	// LastModified is the value of the Last-Modified header, or the empty string if the header is absent.
+	LastModified string
+}
+
+// MetadataKeySetProvider is a KeySetProvider that also provides HTTP caching metadata of the key set. See CachingKeySetProvider.
+type MetadataKeySetProvider interface {
+	KeySetProvider
+
+	// GetWithMetadata is the same as Get, but also returns the HTTP caching metadata of the key set.
+	GetWithMetadata(ctx context.Context) (KeySet, *KeySetMetadata, error)
+}
+
+// NewKeySetMetadata parses the HTTP caching metadata from the headers of a response.
+func NewKeySetMetadata(header http.Header) *KeySetMetadata {
+	m := &KeySetMetadata{
+		ETag:         header.Get(jasperhttp.HeaderNameETag),
+		LastModified: header.Get(jasperhttp.HeaderNameLastModified),
+	}
+	if v := header.Get(jasperhttp.HeaderNameAge); v != "" {
+		if seconds, err := strconv.ParseInt(v, 10, 64); err == nil && seconds >= 0 {
+			m.Age = time.Duration(seconds) * time.Second
+		}
+	}
+	if _, ok := header[http.CanonicalHeaderKey(jasperhttp.HeaderNameCacheControl)]; ok {
+		cacheControl, err := jasperhttp.ParseCacheControl(header)
+		if err != nil {
+			log.Debugf("ignoring Cache-Control headers: %v", err)
+		} else {
+			m.CacheControl = cacheControl
+		}
+	}
+	if v := header.Get(jasperhttp.HeaderNameDate); v != "" {
+		if date, err := http.ParseTime(v); err == nil {
+			m.Date = date
+		}
+	}
+	if v := header.Get(jasperhttp.HeaderNameExpires); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			expires = time.Unix(0, 0)
+		}
+		m.Expires = expires
+	}
+	return m
+}
+
+// TimeToLive returns the remaining freshness lifetime of the key set, as per https://tools.ietf.org/html/rfc7234#section-4.2.
+// ok is false if the metadata does not determine a freshness lifetime. now is used if the Date header is absent.
+func (m *KeySetMetadata) TimeToLive(now time.Time) (timeToLive time.Duration, ok bool) {
+	if m.CacheControl != nil && (m.CacheControl.NoCache || m.CacheControl.NoStore) {
+		return 0, true
+	}
+	var freshnessLifetime time.Duration
+	switch {
+	case m.CacheControl != nil && m.CacheControl.HasMaxAge:
+		freshnessLifetime = m.CacheControl.MaxAge
+	case !m.Expires.IsZero():
+		date := m.Date
+		if date.IsZero() {
+			date = now
+		}
+		freshnessLifetime = m.Expires.Sub(date)
+	default:
+		return 0, false
+	}
+	timeToLive = freshnessLifetime - m.Age
+	if timeToLive < 0 {
+		timeToLive = 0
+	}
+	return timeToLive, true
+}
@@ -0,0 +1,150 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"google.golang.org/api/googleapi"
+
+	"github.com/jbrekelmans/go-lib/cache"
+)
+
+const (
+	// OIDCDiscoveryPath is the path, relative to an issuer URL, of an OpenID Provider Configuration document.
+	// See https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfig
+	OIDCDiscoveryPath = "/.well-known/openid-configuration"
+)
+
+// OIDCConfiguration is the subset of an OpenID Provider Configuration document that is relevant for verifying tokens.
+// See https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type OIDCConfiguration struct {
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+}
+
+// OIDCConfigurationProvider is an interface for getting an OpenID Provider Configuration. See OIDCKeySetProvider.
+type OIDCConfigurationProvider interface {
+	Configuration(ctx context.Context) (*OIDCConfiguration, error)
+}
+
+type oidcKeySetProvider struct {
+	*cachingKeySetProvider
+	configuration cache.CachedEvaluator[*OIDCConfiguration]
+}
+
+type oidcJWKSKeySetProvider struct {
+	configuration cache.CachedEvaluator[*OIDCConfiguration]
+	httpClient    *http.Client
+	// jwks is the KeySetProvider of the jwks_uri of the last configuration. It is replaced if jwks_uri changes.
+	jwks  *httpsKeySetProvider
+	mutex sync.Mutex
+}
+
+// OIDCKeySetProvider gets keys from the JSON Web Key Set of an OpenID Provider, discovered as described by
+// https://openid.net/specs/openid-connect-discovery-1_0.html. issuer must be an https URL without query or fragment. The OpenID Provider
+// Configuration is fetched from issuer + OIDCDiscoveryPath, and is rejected if its issuer is not exactly issuer. The key set is then
+// fetched from the configuration's jwks_uri (see JWKSKeySetProvider).
+// Both the configuration and the key set are cached, where timeToLive and opts have the same meaning as for CachingKeySetProvider.
+// The returned KeySetProvider implements RefreshingKeySetProvider, OIDCConfigurationProvider and io.Closer.
+func OIDCKeySetProvider(httpClient *http.Client, issuer string, timeToLive time.Duration, opts ...CachingKeySetProviderOption) (
+	KeySetProvider, error) {
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("issuer is invalid: %w", err)
+	}
+	if issuerURL.Scheme != "https" || issuerURL.Host == "" || issuerURL.RawQuery != "" || issuerURL.Fragment != "" {
+		return nil, fmt.Errorf("issuer %#v is invalid: must be an https URL without query or fragment", issuer)
+	}
+	if httpClient == nil {
+		httpClient = cleanhttp.DefaultClient()
+	}
+	o := newCachingKeySetProviderOptions(opts)
+	configuration, _ := cache.NewExpiringCachedEvaluator(func(ctx context.Context) (*OIDCConfiguration, time.Time, error) {
+		configuration, metadata, err := getOIDCConfiguration(ctx, httpClient, issuer)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		now := o.clock.Now()
+		return configuration, now.Add(boundedTimeToLive(metadata, now, timeToLive, o.minimumTimeToLive, o.maximumTimeToLive)), nil
+	}, append([]cache.Option{cache.WithClock(o.clock), cache.WithTimeToLive(timeToLive)}, o.cacheOptions...)...)
+	jwks := &oidcJWKSKeySetProvider{
+		configuration: configuration,
+		httpClient:    httpClient,
+	}
+	return &oidcKeySetProvider{
+		cachingKeySetProvider: newCachingKeySetProvider(timeToLive, jwks, o),
+		configuration:         configuration,
+	}, nil
+}
+
+func getOIDCConfiguration(ctx context.Context, httpClient *http.Client, issuer string) (*OIDCConfiguration, *KeySetMetadata, error) {
+	discoveryURL := strings.TrimSuffix(issuer, "/") + OIDCDiscoveryPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating request GET %s: %w", discoveryURL, err)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error doing GET %s: %w", discoveryURL, err)
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, nil, fmt.Errorf("GET %s gave unexpected response: %w", discoveryURL, err)
+	}
+	configuration := &OIDCConfiguration{}
+	if err := json.NewDecoder(res.Body).Decode(configuration); err != nil {
+		return nil, nil, fmt.Errorf("GET %s gave response with unexpected JSON: %w", discoveryURL, err)
+	}
+	if configuration.Issuer != issuer {
+		return nil, nil, fmt.Errorf("GET %s gave configuration with issuer %#v, but expected %#v", discoveryURL,
+			configuration.Issuer, issuer)
+	}
+	if jwksURL, err := url.Parse(configuration.JWKSURI); err != nil || jwksURL.Scheme != "https" || jwksURL.Host == "" {
+		return nil, nil, fmt.Errorf("GET %s gave configuration with jwks_uri %#v, but expected an https URL", discoveryURL,
+			configuration.JWKSURI)
+	}
+	return configuration, NewKeySetMetadata(res.Header), nil
+}
+
+// Configuration implements OIDCConfigurationProvider.
+func (o *oidcKeySetProvider) Configuration(ctx context.Context) (*OIDCConfiguration, error) {
+	return o.configuration.Get(ctx)
+}
+
+// Close stops the refreshers of the caches. See cache.CachedEvaluator.
+func (o *oidcKeySetProvider) Close() error {
+	_ = o.configuration.Close()
+	return o.cachingKeySetProvider.Close()
+}
+
+// Get implements KeySetProvider.
+func (o *oidcJWKSKeySetProvider) Get(ctx context.Context) (KeySet, error) {
+	keySet, _, err := o.GetWithMetadata(ctx)
+	return keySet, err
+}
+
+// GetWithMetadata implements MetadataKeySetProvider.
+func (o *oidcJWKSKeySetProvider) GetWithMetadata(ctx context.Context) (KeySet, *KeySetMetadata, error) {
+	configuration, err := o.configuration.Get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return o.getJWKSLockedSection(configuration.JWKSURI).GetWithMetadata(ctx)
+}
+
+func (o *oidcJWKSKeySetProvider) getJWKSLockedSection(jwksURI string) *httpsKeySetProvider {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.jwks == nil || o.jwks.url != jwksURI {
+		o.jwks = newHTTPSKeySetProvider(o.httpClient, jwksURI, parseJWKS)
+	}
+	return o.jwks
+}
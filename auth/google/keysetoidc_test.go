@@ -0,0 +1,87 @@
+package google
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+func newTestOIDCServer(t *testing.T, issuer *string) (server *httptest.Server, requests map[string]int) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests = map[string]int{}
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests[req.URL.Path]++
+		var body interface{}
+		switch req.URL.Path {
+		case OIDCDiscoveryPath:
+			body = map[string]string{
+				"issuer":   *issuer,
+				"jwks_uri": server.URL + "/jwks",
+			}
+		case "/jwks":
+			body = jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{{Algorithm: "RS256", Key: &key.PublicKey, KeyID: "a", Use: "sig"}},
+			}
+		default:
+			http.NotFound(w, req)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			t.Error(err)
+		}
+	}))
+	*issuer = server.URL
+	return
+}
+
+func Test_OIDCKeySetProvider_Get(t *testing.T) {
+	issuer := ""
+	server, requests := newTestOIDCServer(t, &issuer)
+	defer server.Close()
+	k, err := OIDCKeySetProvider(server.Client(), issuer, DefaultCachingKeySetProviderTimeToLive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		keySet, err := k.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key := keySet["a"]; key == nil || key.Algorithm != "RS256" || len(keySet) != 1 {
+			t.Fatalf("unexpected key set %v", keySet)
+		}
+	}
+	if requests[OIDCDiscoveryPath] != 1 || requests["/jwks"] != 1 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	configuration, err := k.(OIDCConfigurationProvider).Configuration(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration.Issuer != issuer {
+		t.Fatalf("unexpected issuer %#v", configuration.Issuer)
+	}
+}
+
+func Test_OIDCKeySetProvider_Get_IssuerMismatch(t *testing.T) {
+	issuer := ""
+	server, _ := newTestOIDCServer(t, &issuer)
+	defer server.Close()
+	k, err := OIDCKeySetProvider(server.Client(), issuer, DefaultCachingKeySetProviderTimeToLive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer = "https://attacker.example.com"
+	if _, err := k.Get(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
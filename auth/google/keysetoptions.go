@@ -0,0 +1,75 @@
+package google
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/cache"
+	"github.com/jbrekelmans/go-lib/clock"
+)
+
+// CachingKeySetProviderOption is an option that can be passed to CachingKeySetProvider.
+type CachingKeySetProviderOption = func(o *cachingKeySetProviderOptions)
+
+type cachingKeySetProviderOptions struct {
+	cacheOptions           []cache.Option
+	clock                  clock.Clock
+	maximumTimeToLive      time.Duration
+	minimumRefreshInterval time.Duration
+	minimumTimeToLive      time.Duration
+}
+
+func newCachingKeySetProviderOptions(opts []CachingKeySetProviderOption) *cachingKeySetProviderOptions {
+	o := &cachingKeySetProviderOptions{
+		clock:                  clock.System,
+		maximumTimeToLive:      DefaultCachingKeySetProviderMaximumTimeToLive,
+		minimumRefreshInterval: DefaultCachingKeySetProviderMinimumRefreshInterval,
+		minimumTimeToLive:      DefaultCachingKeySetProviderMinimumTimeToLive,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCacheOptions returns an option for CachingKeySetProvider that adds options for the underlying cache.CachedEvaluator. For example,
+// cache.WithStaleIfError can be used to keep serving a stale key set while the base KeySetProvider fails.
+func WithCacheOptions(v ...cache.Option) CachingKeySetProviderOption {
+	return func(o *cachingKeySetProviderOptions) {
+		o.cacheOptions = append(o.cacheOptions, v...)
+	}
+}
+
+// WithClock returns an option for CachingKeySetProvider that sets the clock. This is useful for unit testing, see test.NewFakeClock.
+func WithClock(v clock.Clock) CachingKeySetProviderOption {
+	return func(o *cachingKeySetProviderOptions) {
+		o.clock = v
+	}
+}
+
+// WithMinimumRefreshInterval returns an option for CachingKeySetProvider that sets the minimum interval between refreshes because of
+// unknown key identifiers. See RefreshingKeySetProvider. The default is DefaultCachingKeySetProviderMinimumRefreshInterval.
+func WithMinimumRefreshInterval(v time.Duration) CachingKeySetProviderOption {
+	if v < 0 {
+		panic(fmt.Errorf("v must be non-negative"))
+	}
+	return func(o *cachingKeySetProviderOptions) {
+		o.minimumRefreshInterval = v
+	}
+}
+
+// WithTimeToLiveBounds returns an option for CachingKeySetProvider that sets the bounds of the time to live that is derived from the
+// HTTP caching metadata of a key set. See MetadataKeySetProvider. The defaults are DefaultCachingKeySetProviderMinimumTimeToLive and
+// DefaultCachingKeySetProviderMaximumTimeToLive.
+func WithTimeToLiveBounds(minimum, maximum time.Duration) CachingKeySetProviderOption {
+	if minimum < 0 {
+		panic(fmt.Errorf("minimum must be non-negative"))
+	}
+	if maximum < minimum {
+		panic(fmt.Errorf("maximum must not be less than minimum"))
+	}
+	return func(o *cachingKeySetProviderOptions) {
+		o.maximumTimeToLive = maximum
+		o.minimumTimeToLive = minimum
+	}
+}
@@ -0,0 +1,173 @@
+// Package jwt verifies signed JSON Web Tokens (see https://tools.ietf.org/html/rfc7519) using keys of a google.KeySetProvider.
+package jwt
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jbrekelmans/go-lib/auth"
+	"github.com/jbrekelmans/go-lib/auth/google"
+	"github.com/jbrekelmans/go-lib/clock"
+)
+
+// DefaultAlgorithms are the JWS algorithms that Verifier allows by default. These are all asymmetric algorithms supported by
+// google.Key. See WithAlgorithms.
+var DefaultAlgorithms = []string{
+	string(jose.RS256), string(jose.RS384), string(jose.RS512),
+	string(jose.PS256), string(jose.PS384), string(jose.PS512),
+	string(jose.ES256), string(jose.ES384), string(jose.ES512),
+	string(jose.EdDSA),
+}
+
+// Verifier is a type that verifies signed JWTs. See NewVerifier.
+type Verifier struct {
+	algorithms                 []string
+	audiences                  []string
+	clock                      clock.Clock
+	issuers                    []string
+	jwtClaimsLeeway            time.Duration
+	keyPolicy                  *google.KeyPolicy
+	keySetProvider             google.KeySetProvider
+	maximumJWTNotExpiredPeriod time.Duration
+}
+
+// NewVerifier is the constructor for Verifier. keySetProvider provides the keys used for signature verification. If keySetProvider
+// implements google.RefreshingKeySetProvider then the key set is refreshed when a JWT is signed with an unknown key.
+func NewVerifier(keySetProvider google.KeySetProvider, opts ...VerifierOption) (*Verifier, error) {
+	if keySetProvider == nil {
+		return nil, fmt.Errorf("keySetProvider must not be nil")
+	}
+	v := &Verifier{
+		algorithms:                 DefaultAlgorithms,
+		clock:                      clock.System,
+		jwtClaimsLeeway:            auth.DefaultJWTClaimsLeeway,
+		keySetProvider:             keySetProvider,
+		maximumJWTNotExpiredPeriod: auth.DefaultMaximumJWTNotExpiredPeriod,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	if len(v.algorithms) == 0 {
+		return nil, fmt.Errorf("at least one algorithm must be allowed")
+	}
+	return v, nil
+}
+
+// Verify verifies the signature and the registered claims of jwtString, and decodes the claims into the standard claims (which are
+// returned) and into each of claims. Each of claims should be a pointer to a struct or map that the JSON claims can be unmarshalled into,
+// just like "github.com/go-jose/go-jose/v3/jwt".JSONWebToken.Claims.
+// The registered claims are verified as follows: "exp" is required, "exp" and "nbf" must hold at the time of the clock (allowing for the
+// leeway), "exp" must be at most the maximum not-expired period in the future, and "iss" and "aud" must be allowed (if configured).
+// If the returned error is a *VerifyError then jwtString was successfully determined to be invalid.
+// Otherwise, if an error is returned, the verification attempt failed.
+func (v *Verifier) Verify(ctx context.Context, jwtString string, claims ...interface{}) (*jwt.Claims, error) {
+	jwtParsed, err := jwt.ParseSigned(jwtString)
+	if err != nil {
+		return nil, &VerifyError{e: fmt.Sprintf("error parsing jwtString as signed JWT: %v", err)}
+	}
+	if len(jwtParsed.Headers) != 1 {
+		return nil, &VerifyError{e: "jwtString must encode a JWT with exactly one header"}
+	}
+	header := jwtParsed.Headers[0]
+	if !contains(v.algorithms, header.Algorithm) {
+		return nil, &VerifyError{e: fmt.Sprintf("JWT has algorithm %#v, but only %v are allowed", header.Algorithm, v.algorithms)}
+	}
+	key, err := v.getKey(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+		return nil, &VerifyError{e: fmt.Sprintf("JWT has algorithm %#v, but the key with identifier %#v is for algorithm %#v",
+			header.Algorithm, header.KeyID, key.Algorithm)}
+	}
+	if v.keyPolicy != nil {
+		if err := v.keyPolicy.Check(key, v.clock.Now()); err != nil {
+			return nil, &VerifyError{e: fmt.Sprintf("key with identifier %#v is not allowed: %v", header.KeyID, err)}
+		}
+	}
+	standardClaims := &jwt.Claims{}
+	if err := jwtParsed.Claims(key.PublicKey, append([]interface{}{standardClaims}, claims...)...); err != nil {
+		return nil, &VerifyError{e: fmt.Sprintf("error verifying JWT signature or decoding claims: %v", err)}
+	}
+	if err := v.validateClaims(standardClaims); err != nil {
+		return nil, err
+	}
+	return standardClaims, nil
+}
+
+func (v *Verifier) getKey(ctx context.Context, keyID string) (*google.Key, error) {
+	keySet, err := v.keySetProvider.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting public key used for JWT signature verification: %w", err)
+	}
+	key, ok := keySet[keyID]
+	if !ok {
+		// The issuer may have rotated its keys.
+		if refresher, isRefresher := v.keySetProvider.(google.RefreshingKeySetProvider); isRefresher {
+			keySet, err = refresher.Refresh(ctx, keyID)
+			if err != nil {
+				return nil, fmt.Errorf("error refreshing key set because it has no key with identifier %#v: %w", keyID, err)
+			}
+			key, ok = keySet[keyID]
+		}
+		if !ok {
+			return nil, &VerifyError{e: fmt.Sprintf("no key with identifier %#v exists", keyID)}
+		}
+	}
+	return key, nil
+}
+
+func (v *Verifier) validateClaims(c *jwt.Claims) error {
+	log.Tracef("Claims: %+v", c)
+	now := v.clock.Now()
+	if err := c.ValidateWithLeeway(jwt.Expected{Time: now}, v.jwtClaimsLeeway); err != nil {
+		return &VerifyError{e: err.Error()}
+	}
+	if len(v.issuers) > 0 && !contains(v.issuers, c.Issuer) {
+		return &VerifyError{e: fmt.Sprintf(`JWT has claim "iss" with value %#v, but only %v are allowed`, c.Issuer, v.issuers)}
+	}
+	if len(v.audiences) > 0 {
+		found := false
+		for _, audience := range v.audiences {
+			if c.Audience.Contains(audience) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &VerifyError{e: fmt.Sprintf(`JWT has claim "aud" with value %v, but it must contain one of %v`, c.Audience, v.audiences)}
+		}
+	}
+	if c.Expiry == nil {
+		return &VerifyError{e: `JWT does not have required claim "exp"`}
+	}
+	notExpiredPeriod := c.Expiry.Time().Sub(now)
+	if notExpiredPeriod-v.jwtClaimsLeeway > v.maximumJWTNotExpiredPeriod {
+		return &VerifyError{e: fmt.Sprintf(`JWT must expire after at most %v, but it expires after %v`, v.maximumJWTNotExpiredPeriod,
+			notExpiredPeriod-v.jwtClaimsLeeway)}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyError communicates that a successful verification attempt resulted in a negative response.
+type VerifyError struct {
+	e string
+}
+
+func (v *VerifyError) Error() string {
+	return v.e
+}
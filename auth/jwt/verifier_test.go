@@ -0,0 +1,120 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+
+	"github.com/jbrekelmans/go-lib/auth/google"
+	"github.com/jbrekelmans/go-lib/test"
+)
+
+const testKeyID = "a"
+
+var testTimeNow = time.Date(2020, 5, 16, 0, 0, 0, 0, time.UTC)
+
+type testKeySetProvider google.KeySet
+
+func (t testKeySetProvider) Get(ctx context.Context) (google.KeySet, error) {
+	return google.KeySet(t), nil
+}
+
+type testCustomClaims struct {
+	Email string `json:"email"`
+}
+
+func setup(t *testing.T, opts ...VerifierOption) (v *Verifier, sign func(algorithm jose.SignatureAlgorithm, claims ...interface{}) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]VerifierOption{
+		WithAudience("https://example.com/"),
+		WithClock(test.NewFakeClock(testTimeNow)),
+		WithIssuer("https://issuer.example.com"),
+	}, opts...)
+	v, err = NewVerifier(testKeySetProvider{
+		testKeyID: {Algorithm: string(jose.RS256), PublicKey: &key.PublicKey},
+	}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign = func(algorithm jose.SignatureAlgorithm, claims ...interface{}) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: key},
+			(&jose.SignerOptions{}).WithHeader(jose.HeaderKey("kid"), testKeyID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := jwt.Signed(signer)
+		for _, c := range claims {
+			builder = builder.Claims(c)
+		}
+		s, err := builder.CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	return
+}
+
+func testClaims() *jwt.Claims {
+	return &jwt.Claims{
+		Audience: jwt.Audience{"https://example.com/"},
+		Expiry:   jwt.NewNumericDate(testTimeNow.Add(time.Minute * 10)),
+		IssuedAt: jwt.NewNumericDate(testTimeNow),
+		Issuer:   "https://issuer.example.com",
+		Subject:  "subject",
+	}
+}
+
+func Test_Verifier_Verify_Success(t *testing.T) {
+	v, sign := setup(t)
+	customClaims := &testCustomClaims{}
+	claims, err := v.Verify(context.Background(), sign(jose.RS256, testClaims(), map[string]interface{}{"email": "a@example.com"}), customClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "subject" || customClaims.Email != "a@example.com" {
+		t.Fatalf("unexpected claims %+v %+v", claims, customClaims)
+	}
+}
+
+func Test_Verifier_Verify_Invalid(t *testing.T) {
+	v, sign := setup(t)
+	wrongIssuer := testClaims()
+	wrongIssuer.Issuer = "https://attacker.example.com"
+	wrongAudience := testClaims()
+	wrongAudience.Audience = jwt.Audience{"https://other.example.com/"}
+	expired := testClaims()
+	expired.Expiry = jwt.NewNumericDate(testTimeNow.Add(-time.Hour))
+	tooLong := testClaims()
+	tooLong.Expiry = jwt.NewNumericDate(testTimeNow.Add(time.Hour * 24))
+	noExpiry := testClaims()
+	noExpiry.Expiry = nil
+	for name, jwtString := range map[string]string{
+		"algorithm not allowed for key": sign(jose.PS256, testClaims()),
+		"expired":                       sign(jose.RS256, expired),
+		"malformed":                     "a.b.c",
+		"no expiry":                     sign(jose.RS256, noExpiry),
+		"too long":                      sign(jose.RS256, tooLong),
+		"wrong audience":                sign(jose.RS256, wrongAudience),
+		"wrong issuer":                  sign(jose.RS256, wrongIssuer),
+	} {
+		_, err := v.Verify(context.Background(), jwtString)
+		if _, ok := err.(*VerifyError); !ok {
+			t.Logf("%s: expected *VerifyError, but got %v", name, err)
+			t.Fail()
+		}
+	}
+
+	v, sign = setup(t, WithAlgorithms(string(jose.ES256)))
+	if _, err := v.Verify(context.Background(), sign(jose.RS256, testClaims())); err == nil {
+		t.Fatal("expected error because algorithm is not allowed")
+	}
+}
@@ -0,0 +1,72 @@
+package jwt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/auth/google"
+	"github.com/jbrekelmans/go-lib/clock"
+)
+
+// VerifierOption is an option that can be passed to NewVerifier.
+type VerifierOption = func(v *Verifier)
+
+// WithAlgorithms returns an option for NewVerifier that sets the allowed JWS algorithms, for example "RS256". The default is
+// DefaultAlgorithms.
+func WithAlgorithms(v ...string) VerifierOption {
+	return func(w *Verifier) {
+		w.algorithms = v
+	}
+}
+
+// WithAudience returns an option for NewVerifier that adds an allowed audience. If any audiences are allowed then the "aud" claim of a
+// JWT must contain at least one of them. By default the "aud" claim is not verified.
+func WithAudience(v string) VerifierOption {
+	return func(w *Verifier) {
+		w.audiences = append(w.audiences, v)
+	}
+}
+
+// WithClock returns an option for NewVerifier that sets the clock. This is useful for unit testing, see test.NewFakeClock.
+func WithClock(v clock.Clock) VerifierOption {
+	return func(w *Verifier) {
+		w.clock = v
+	}
+}
+
+// WithIssuer returns an option for NewVerifier that adds an allowed issuer. If any issuers are allowed then the "iss" claim of a JWT must
+// be one of them. By default the "iss" claim is not verified.
+func WithIssuer(v string) VerifierOption {
+	return func(w *Verifier) {
+		w.issuers = append(w.issuers, v)
+	}
+}
+
+// WithJWTClaimsLeeway returns an option for NewVerifier that sets the leeway when validating JWT claims.
+// See https://pkg.go.dev/github.com/go-jose/go-jose/v3/jwt#Claims.ValidateWithLeeway
+func WithJWTClaimsLeeway(v time.Duration) VerifierOption {
+	if v < 0 {
+		panic(fmt.Errorf("v must be non-negative"))
+	}
+	return func(w *Verifier) {
+		w.jwtClaimsLeeway = v
+	}
+}
+
+// WithKeyPolicy returns an option for NewVerifier that sets the policy that the key used to sign a JWT must satisfy, at the time of the
+// clock. By default no policy is enforced.
+func WithKeyPolicy(v *google.KeyPolicy) VerifierOption {
+	return func(w *Verifier) {
+		w.keyPolicy = v
+	}
+}
+
+// WithMaximumJWTNotExpiredPeriod returns an option for NewVerifier that sets the maximum allowed period that a JWT does not expire.
+func WithMaximumJWTNotExpiredPeriod(v time.Duration) VerifierOption {
+	if v < 0 {
+		panic(fmt.Errorf("v must be non-negative"))
+	}
+	return func(w *Verifier) {
+		w.maximumJWTNotExpiredPeriod = v
+	}
+}
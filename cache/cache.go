@@ -3,30 +3,93 @@ package cache
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/clock"
 )
 
+// MinimumRefreshAheadDelay is the minimum delay of a background evaluation scheduled by the refresher, see WithRefreshAhead.
+const MinimumRefreshAheadDelay = time.Second
+
 // CachedEvaluator is a cache for an evaluator (a function) such that the evaluator is expensive enough to justify ensuring that only
 // one Goroutine should be running the evaluator at any one time (and other Goroutines will wait as needed).
-// A CachedEvaluator should be wrapped for type-safety.
 // See NewCachedEvaluator.
-type CachedEvaluator interface {
-	GetCacheOnly() (value interface{})
+type CachedEvaluator[T any] interface {
+	// GetCacheOnly returns the cached value without evaluating. ok is false if no value is cached or if the cached value has expired.
+	GetCacheOnly() (value T, ok bool)
 
-	Get(ctx context.Context) (value interface{}, err error)
+	// Get returns the cached value. If no value is cached or the cached value has expired then Get waits for an evaluation in the same
+	// way as Evaluate.
+	Get(ctx context.Context) (value T, err error)
 
 	// Evaluate is the same as Get, except:
 	// Evaluate always ensures a Goroutine is evaluating. If there are no Goroutines evaluating then
 	// evaluator is called.
-	Evaluate(ctx context.Context) (value interface{}, err error)
+	Evaluate(ctx context.Context) (value T, err error)
+
+	// Invalidate drops the cached value, so that the next call to Get evaluates. The result of an evaluation that is ongoing while
+	// Invalidate is called is not cached.
+	Invalidate()
+
+	// InvalidateIf is the same as Invalidate, except that the cached value is only dropped if predicate returns true for it.
+	// InvalidateIf returns true if the cached value was dropped. predicate is called with an internal mutex locked.
+	InvalidateIf(predicate func(value T) bool) bool
+
+	// Set caches value as if an evaluation returned value. The result of an evaluation that is ongoing while Set is called is not
+	// cached.
+	Set(value T)
+
+	// Close stops the refresher (see WithRefreshAhead) and cancels any background evaluation. Get and Evaluate can still be called
+	// after Close, but no more background evaluations are started.
+	Close() error
+}
+
+type cachedEvaluator[T any] struct {
+	// backgroundContext is the parent context of background evaluations and is canceled by Close.
+	backgroundContext    context.Context
+	backgroundCancelFunc context.CancelFunc
+	closed               bool
+	entry                atomic.Pointer[entry[T]]
+	evaluator            func(ctx context.Context) (T, time.Time, error)
+	failure              atomic.Pointer[failure]
+	// invalidatedSeq is the value of seq when Invalidate was last called. Results of operations with a seq less than or equal to
+	// invalidatedSeq are not cached.
+	invalidatedSeq uint64
+	mutex          sync.Mutex
+	operation      *operation[T]
+	refreshTimer   clock.Timer
+	seq            uint64
+	options
 }
 
-type cachedEvaluator struct {
-	evaluator func(ctx context.Context) (interface{}, error)
-	mutex     sync.Mutex
-	value     atomic.Value
-	operation *operation
+// entry is a cached value. If expires is the zero time then the value never expires.
+// seq is the seq of the operation that evaluated the value, and is used to ensure that an older evaluation never overwrites the
+// result of a newer evaluation.
+type entry[T any] struct {
+	expires time.Time
+	seq     uint64
+	value   T
+}
+
+func (e *entry[T]) isExpired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// isUsable returns true if the value has not expired or is stale for a period less than stalePeriod.
+func (e *entry[T]) isUsable(now time.Time, stalePeriod time.Duration) bool {
+	return e.expires.IsZero() || now.Before(e.expires.Add(stalePeriod))
+}
+
+// failure records consecutive failed evaluations, see WithErrorTimeToLive and WithErrorBackoff.
+// err is the error of the last failed evaluation. Get does not evaluate before retryAfter.
+type failure struct {
+	count      int
+	err        error
+	retryAfter time.Time
 }
 
 // operation represents an ongoing evaluation and stores informaton related to Goroutines
@@ -41,91 +104,262 @@ type cachedEvaluator struct {
 // 3. CX is canceled and the evaluation returns an error because CX is done.
 // 4. Since the evaluation completed, Goroutine Y returns the error.
 // This is not optimal because the evaluation did not have to be canceled, because Goroutine Y was waiting for it.
+// A background evaluation (see Get) holds a reference to itself until it completes so that it is not canceled when no Goroutine is
+// waiting. refs is guarded by the mutex of the cachedEvaluator.
 //
-// value and err are set to the return values of the call to factory.
-// waitChannel is closed after value and err are set.
-type operation struct {
+// value, expires and err are set to the return values of the call to factory.
+// waitChannel is closed after value, expires and err are set.
+type operation[T any] struct {
 	cancelFunc  context.CancelFunc
 	err         error
+	expires     time.Time
 	refs        int64
-	value       interface{}
-	waitChannel <-chan struct{}
+	seq         uint64
+	value       T
+	waitChannel chan struct{}
 }
 
-func (o *operation) addRef() {
-	atomic.AddInt64(&o.refs, 1)
-}
-
-func (o *operation) removeRef() {
-	if atomic.AddInt64(&o.refs, -1) == 0 {
-		o.cancelFunc()
+// NewCachedEvaluator returns a cache for calls to evaluator, as defined by CachedEvaluator.
+// A value is cached if and only if evaluator returns a nil error. Values expire as configured by WithTimeToLive, and never expire
+// by default.
+func NewCachedEvaluator[T any](evaluator func(ctx context.Context) (value T, err error), opts ...Option) (CachedEvaluator[T], error) {
+	if evaluator == nil {
+		return nil, fmt.Errorf("evaluator must not be nil")
 	}
+	return NewExpiringCachedEvaluator(func(ctx context.Context) (value T, expires time.Time, err error) {
+		value, err = evaluator(ctx)
+		return
+	}, opts...)
 }
 
-// NewCachedEvaluator returns a cache for calls to evaluator, as defined by CachedEvaluator.
-func NewCachedEvaluator(evaluator func(ctx context.Context) (value interface{}, err error)) (CachedEvaluator, error) {
+// NewExpiringCachedEvaluator is the same as NewCachedEvaluator, except that evaluator also returns the time at which the value
+// expires. This supports values with an absolute deadline as well as values whose lifetime is derived from the value itself (such as
+// tokens that carry their own expiry). If evaluator returns the zero time then the value expires as configured by WithTimeToLive.
+func NewExpiringCachedEvaluator[T any](evaluator func(ctx context.Context) (value T, expires time.Time, err error),
+	opts ...Option) (CachedEvaluator[T], error) {
 	if evaluator == nil {
 		return nil, fmt.Errorf("evaluator must not be nil")
 	}
-	return &cachedEvaluator{
+	c := &cachedEvaluator[T]{
 		evaluator: evaluator,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(&c.options)
+	}
+	if c.clock == nil {
+		c.clock = clock.System
+	}
+	if c.observer == nil {
+		c.observer = NopObserver{}
+	}
+	c.backgroundContext, c.backgroundCancelFunc = context.WithCancel(context.Background())
+	return c, nil
 }
 
-func (c *cachedEvaluator) GetCacheOnly() (value interface{}) {
-	return c.value.Load()
+func (c *cachedEvaluator[T]) GetCacheOnly() (value T, ok bool) {
+	e := c.entry.Load()
+	if e == nil || e.isExpired(c.clock.Now()) {
+		return
+	}
+	return e.value, true
 }
 
-func (c *cachedEvaluator) evaluateLockedSection() *operation {
+// startOperationLockedSection returns the ongoing operation, starting one if there is no ongoing operation.
+// If background is false then a reference is added to the returned operation and the caller must call c.wait.
+// If background is true then nil is returned, and no operation is started if an operation is ongoing, c is closed or c is backing off
+// after a failed evaluation.
+func (c *cachedEvaluator[T]) startOperationLockedSection(background bool) *operation[T] {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	if c.operation != nil {
-		return c.operation
+	o := c.operation
+	if o != nil {
+		if background {
+			return nil
+		}
+		o.refs++
+		return o
 	}
-	waitChannel := make(chan struct{})
-	o := &operation{
-		waitChannel: waitChannel,
+	parentContext := context.Background()
+	if background {
+		if c.closed {
+			return nil
+		}
+		if f := c.failure.Load(); f != nil && c.clock.Now().Before(f.retryAfter) {
+			return nil
+		}
+		parentContext = c.backgroundContext
 	}
+	c.seq++
+	o = &operation[T]{
+		seq:         c.seq,
+		waitChannel: make(chan struct{}),
+	}
+	o.refs = 1
 	var ctx context.Context
-	ctx, o.cancelFunc = context.WithCancel(context.Background())
+	ctx, o.cancelFunc = context.WithCancel(parentContext)
 	go func() {
-		didPanic := true
-		defer func() {
-			close(waitChannel)
-			if didPanic {
-				c.mutex.Lock()
-				defer c.mutex.Unlock()
-				c.operation = nil
-			}
-		}()
-		o.value, o.err = c.evaluator(ctx)
-		didPanic = false
-		c.mutex.Lock()
-		defer c.mutex.Unlock()
-		c.operation = nil
-		c.value.Store(o.value)
+		ctx := c.observer.OnEvaluateStart(ctx, background)
+		// The duration of the evaluation is measured with the system clock, because it is not used for expiry.
+		start := time.Now()
+		panicValue := c.evaluate(ctx, o)
+		c.observer.OnEvaluateEnd(ctx, time.Since(start), o.err)
+		c.completeOperation(ctx, o, background)
+		if panicValue != nil && c.propagatePanics {
+			panic(panicValue)
+		}
 	}()
 	c.operation = o
+	if background {
+		return nil
+	}
 	return o
 }
 
-func (c *cachedEvaluator) Get(ctx context.Context) (value interface{}, err error) {
-	value = c.value.Load()
-	if value != nil {
+// evaluate calls c.evaluator and sets the fields of o accordingly. If c.evaluator panics then o.err is set to a *PanicError and the
+// value passed to panic is returned.
+func (c *cachedEvaluator[T]) evaluate(ctx context.Context, o *operation[T]) (panicValue interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicValue = r
+			o.err = &PanicError{
+				Stack: debug.Stack(),
+				Value: r,
+			}
+		}
+	}()
+	o.value, o.expires, o.err = c.evaluator(ctx)
+	if o.err == nil && o.expires.IsZero() && c.timeToLive > 0 {
+		o.expires = c.clock.Now().Add(c.timeToLive)
+	}
+	return
+}
+
+// completeOperation stores the result of o and notifies Goroutines waiting for o.
+func (c *cachedEvaluator[T]) completeOperation(ctx context.Context, o *operation[T], background bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e := c.entry.Load(); o.seq > c.invalidatedSeq && (e == nil || e.seq < o.seq) {
+		if o.err == nil {
+			c.entry.Store(&entry[T]{
+				expires: o.expires,
+				seq:     o.seq,
+				value:   o.value,
+			})
+			c.failure.Store(nil)
+			c.scheduleRefreshLockedSection(o.expires)
+		} else if ctx.Err() == nil {
+			// Only record failures that are not caused by canceling the operation.
+			c.recordFailureLockedSection(o.err)
+			if background && e != nil {
+				// Retry so that a single failed background evaluation does not disable the refresher.
+				c.scheduleRefreshLockedSection(e.expires)
+			}
+		}
+	}
+	c.completeLockedSection(o, background)
+}
+
+// recordFailureLockedSection records a failed evaluation, as configured by WithErrorTimeToLive and WithErrorBackoff. It must be called
+// with c.mutex locked.
+func (c *cachedEvaluator[T]) recordFailureLockedSection(err error) {
+	if c.errorTimeToLive == 0 && c.errorBackoffInitial == 0 {
 		return
 	}
-	return c.Evaluate(ctx)
+	f := &failure{
+		count: 1,
+		err:   err,
+	}
+	if previous := c.failure.Load(); previous != nil {
+		f.count = previous.count + 1
+	}
+	period := c.errorTimeToLive
+	if c.errorBackoffInitial > 0 {
+		backoff := c.errorBackoffInitial
+		for i := 1; i < f.count && backoff < c.errorBackoffMaximum; i++ {
+			backoff *= 2
+		}
+		if backoff > c.errorBackoffMaximum {
+			backoff = c.errorBackoffMaximum
+		}
+		backoff -= time.Duration(c.errorBackoffJitter * rand.Float64() * float64(backoff))
+		if backoff > period {
+			period = backoff
+		}
+	}
+	f.retryAfter = c.clock.Now().Add(period)
+	c.failure.Store(f)
 }
 
-func (c *cachedEvaluator) Evaluate(ctx context.Context) (value interface{}, err error) {
-	o := c.evaluateLockedSection()
-	o.addRef()
+// scheduleRefreshLockedSection schedules a background evaluation ahead of expires, as configured by WithRefreshAhead. It must be
+// called with c.mutex locked.
+// The evaluation is delayed by at least MinimumRefreshAheadDelay and until the backoff after a failed evaluation has elapsed, and is not
+// scheduled at all if that is not before expires. This avoids a tight loop of background evaluations if the evaluator returns values
+// that expire immediately.
+func (c *cachedEvaluator[T]) scheduleRefreshLockedSection(expires time.Time) {
+	if c.refreshAheadFraction == 0 || c.closed || expires.IsZero() {
+		return
+	}
+	if c.refreshTimer != nil {
+		c.refreshTimer.Stop()
+		c.refreshTimer = nil
+	}
+	now := c.clock.Now()
+	timeToLive := expires.Sub(now)
+	fraction := c.refreshAheadFraction - c.refreshAheadJitter*rand.Float64()
+	delay := time.Duration(float64(timeToLive) * fraction)
+	if delay < MinimumRefreshAheadDelay {
+		delay = MinimumRefreshAheadDelay
+	}
+	if f := c.failure.Load(); f != nil && f.retryAfter.Sub(now) > delay {
+		delay = f.retryAfter.Sub(now)
+	}
+	if delay >= timeToLive {
+		return
+	}
+	c.refreshTimer = c.clock.AfterFunc(delay, func() {
+		c.startOperationLockedSection(true)
+	})
+}
+
+// completeLockedSection must be called with c.mutex locked.
+func (c *cachedEvaluator[T]) completeLockedSection(o *operation[T], background bool) {
+	if c.operation == o {
+		c.operation = nil
+	}
+	close(o.waitChannel)
+	if background {
+		c.releaseLockedSection(o)
+	}
+}
+
+// releaseLockedSection removes a reference from o and cancels o if no references remain. It must be called with c.mutex locked.
+// A canceled operation is detached so that subsequent calls start a new operation instead of waiting for the canceled operation.
+func (c *cachedEvaluator[T]) releaseLockedSection(o *operation[T]) {
+	o.refs--
+	if o.refs == 0 {
+		select {
+		case <-o.waitChannel:
+		default:
+			c.observer.OnCancel()
+		}
+		o.cancelFunc()
+		if c.operation == o {
+			c.operation = nil
+		}
+	}
+}
+
+// wait waits for o to complete or ctx to be done, whichever happens first.
+func (c *cachedEvaluator[T]) wait(ctx context.Context, o *operation[T]) (value T, err error) {
+	c.observer.OnWaitStart()
 	defer func() {
-		o.removeRef()
+		c.observer.OnWaitEnd(err)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.releaseLockedSection(o)
 	}()
 	select {
 	case <-ctx.Done():
-		value = nil
 		err = ctx.Err()
 		return
 	case <-o.waitChannel:
@@ -133,3 +367,132 @@ func (c *cachedEvaluator) Evaluate(ctx context.Context) (value interface{}, err
 		return
 	}
 }
+
+// Get is documented in CachedEvaluator. If the cached value has expired, but is stale for less than the period set by
+// WithStaleWhileRevalidate, then the stale value is returned and a background evaluation is started. If the cached value is stale
+// for less than the period set by WithStaleIfError and the evaluation fails, then the stale value is returned instead of the error.
+// If the last evaluation failed and the period set by WithErrorTimeToLive or WithErrorBackoff has not elapsed, then Get does not
+// evaluate and returns the error of the last evaluation (or the stale value, as per WithStaleIfError).
+func (c *cachedEvaluator[T]) Get(ctx context.Context) (value T, err error) {
+	e := c.entry.Load()
+	now := c.clock.Now()
+	if e != nil {
+		if !e.isExpired(now) {
+			c.observer.OnHit(false)
+			return e.value, nil
+		}
+		if e.isUsable(now, c.staleWhileRevalidate) {
+			c.observer.OnHit(true)
+			c.startOperationLockedSection(true)
+			return e.value, nil
+		}
+	}
+	if f := c.failure.Load(); f != nil && now.Before(f.retryAfter) {
+		if e != nil && e.isUsable(now, c.staleIfError) {
+			c.observer.OnHit(true)
+			return e.value, nil
+		}
+		c.observer.OnMiss()
+		err = f.err
+		return
+	}
+	c.observer.OnMiss()
+	value, err = c.Evaluate(ctx)
+	if err != nil && e != nil && ctx.Err() == nil && e.isUsable(c.clock.Now(), c.staleIfError) {
+		return e.value, nil
+	}
+	return
+}
+
+func (c *cachedEvaluator[T]) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.invalidateLockedSection()
+}
+
+func (c *cachedEvaluator[T]) InvalidateIf(predicate func(value T) bool) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e := c.entry.Load()
+	if e == nil || !predicate(e.value) {
+		return false
+	}
+	c.invalidateLockedSection()
+	return true
+}
+
+// invalidateLockedSection must be called with c.mutex locked. The ongoing operation (if any) is detached so that subsequent calls
+// start a new evaluation.
+func (c *cachedEvaluator[T]) invalidateLockedSection() {
+	c.invalidatedSeq = c.seq
+	c.entry.Store(nil)
+	c.failure.Store(nil)
+	c.operation = nil
+	if c.refreshTimer != nil {
+		c.refreshTimer.Stop()
+		c.refreshTimer = nil
+	}
+}
+
+func (c *cachedEvaluator[T]) Set(value T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seq++
+	var expires time.Time
+	if c.timeToLive > 0 {
+		expires = c.clock.Now().Add(c.timeToLive)
+	}
+	c.entry.Store(&entry[T]{
+		expires: expires,
+		seq:     c.seq,
+		value:   value,
+	})
+	c.failure.Store(nil)
+	c.scheduleRefreshLockedSection(expires)
+}
+
+// isEvaluating returns true if c has an ongoing operation.
+func (c *cachedEvaluator[T]) isEvaluating() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.operation != nil
+}
+
+// isReclaimable returns true if c has no ongoing operation, no usable cached value and no failure that Get would return, such that
+// dropping c does not change the results of Get.
+func (c *cachedEvaluator[T]) isReclaimable() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.operation != nil {
+		return false
+	}
+	now := c.clock.Now()
+	stalePeriod := c.staleWhileRevalidate
+	if c.staleIfError > stalePeriod {
+		stalePeriod = c.staleIfError
+	}
+	if e := c.entry.Load(); e != nil && e.isUsable(now, stalePeriod) {
+		return false
+	}
+	if f := c.failure.Load(); f != nil && now.Before(f.retryAfter) {
+		return false
+	}
+	return true
+}
+
+func (c *cachedEvaluator[T]) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	if c.refreshTimer != nil {
+		c.refreshTimer.Stop()
+		c.refreshTimer = nil
+	}
+	c.backgroundCancelFunc()
+	return nil
+}
+
+func (c *cachedEvaluator[T]) Evaluate(ctx context.Context) (value T, err error) {
+	o := c.startOperationLockedSection(false)
+	return c.wait(ctx, o)
+}
@@ -0,0 +1,447 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/test"
+)
+
+func Test_CachedEvaluator_Get_CachesValue(t *testing.T) {
+	var calls int64
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int, error) {
+		return int(atomic.AddInt64(&calls, 1)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.GetCacheOnly(); ok {
+		t.Fatal("expected no value to be cached")
+	}
+	for i := 0; i < 3; i++ {
+		value, err := c.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != 1 {
+			t.Fatalf("unexpected value %d", value)
+		}
+	}
+	value, err := c.Evaluate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 2 {
+		t.Fatalf("unexpected value %d", value)
+	}
+}
+
+func Test_CachedEvaluator_Get_ErrorIsNotCached(t *testing.T) {
+	var calls int64
+	c, err := NewCachedEvaluator(func(ctx context.Context) (string, error) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			return "", fmt.Errorf("error")
+		}
+		return "value", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, ok := c.GetCacheOnly(); ok {
+		t.Fatal("expected no value to be cached")
+	}
+	value, err := c.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "value" {
+		t.Fatalf("unexpected value %#v", value)
+	}
+}
+
+func Test_CachedEvaluator_Get_SingleFlight(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		return 1, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expected evaluator to be called once, but it was called %d times", calls)
+	}
+}
+
+func Test_CachedEvaluator_Evaluate_CanceledWhenNoGoroutineWaits(t *testing.T) {
+	canceled := make(chan struct{})
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		close(canceled)
+		return 0, ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Evaluate(ctx); err != context.Canceled {
+		t.Fatalf("unexpected error %v", err)
+	}
+	<-canceled
+}
+
+func Test_UntypedCachedEvaluator_Get_NilIsNotCached(t *testing.T) {
+	var calls int64
+	u, err := NewUntypedCachedEvaluator(func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			return nil, nil
+		}
+		return "value", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := u.Get(context.Background())
+	if err != nil || value != nil {
+		t.Fatalf("unexpected result %#v, %v", value, err)
+	}
+	value, err = u.Get(context.Background())
+	if err != nil || value != "value" {
+		t.Fatalf("unexpected result %#v, %v", value, err)
+	}
+	if u.GetCacheOnly() != "value" {
+		t.Fatal("expected value to be cached")
+	}
+}
+
+func Test_CachedEvaluator_Get_ExpiredValueIsMissing(t *testing.T) {
+	var calls int64
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	c, err := NewExpiringCachedEvaluator(func(ctx context.Context) (int, time.Time, error) {
+		n := atomic.AddInt64(&calls, 1)
+		if n == 1 {
+			return int(n), fakeClock.Now().Add(time.Second), nil
+		}
+		return int(n), time.Time{}, nil
+	}, WithClock(fakeClock), WithTimeToLive(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := c.Get(context.Background())
+	if err != nil || value != 1 {
+		t.Fatalf("unexpected result %d, %v", value, err)
+	}
+	fakeClock.Advance(time.Second)
+	if _, ok := c.GetCacheOnly(); ok {
+		t.Fatal("expected expired value to be treated as missing")
+	}
+	value, err = c.Get(context.Background())
+	if err != nil || value != 2 {
+		t.Fatalf("unexpected result %d, %v", value, err)
+	}
+	fakeClock.Advance(time.Hour - time.Nanosecond)
+	value, err = c.Get(context.Background())
+	if err != nil || value != 2 {
+		t.Fatalf("unexpected result %d, %v", value, err)
+	}
+	fakeClock.Advance(time.Nanosecond)
+	value, err = c.Get(context.Background())
+	if err != nil || value != 3 {
+		t.Fatalf("unexpected result %d, %v", value, err)
+	}
+}
+
+func Test_CachedEvaluator_Get_StaleWhileRevalidate(t *testing.T) {
+	var calls int64
+	evaluated := make(chan struct{}, 2)
+	c, err := NewExpiringCachedEvaluator(func(ctx context.Context) (int, time.Time, error) {
+		n := atomic.AddInt64(&calls, 1)
+		defer func() {
+			evaluated <- struct{}{}
+		}()
+		if n == 1 {
+			return int(n), time.Now().Add(-time.Second), nil
+		}
+		return int(n), time.Now().Add(time.Hour), nil
+	}, WithStaleWhileRevalidate(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := c.Evaluate(context.Background())
+	if err != nil || value != 1 {
+		t.Fatalf("unexpected result %d, %v", value, err)
+	}
+	<-evaluated
+	value, err = c.Get(context.Background())
+	if err != nil || value != 1 {
+		t.Fatalf("expected stale value, but got %d, %v", value, err)
+	}
+	<-evaluated
+	for {
+		if value, ok := c.GetCacheOnly(); ok {
+			if value != 2 {
+				t.Fatalf("unexpected value %d", value)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_CachedEvaluator_Get_StaleIfError(t *testing.T) {
+	var calls int64
+	c, err := NewExpiringCachedEvaluator(func(ctx context.Context) (int, time.Time, error) {
+		n := atomic.AddInt64(&calls, 1)
+		if n == 1 {
+			return int(n), time.Now().Add(-time.Second), nil
+		}
+		return 0, time.Time{}, fmt.Errorf("error")
+	}, WithStaleIfError(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Evaluate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	value, err := c.Get(context.Background())
+	if err != nil || value != 1 {
+		t.Fatalf("expected stale value, but got %d, %v", value, err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected evaluator to be called twice, but it was called %d times", calls)
+	}
+	if _, err := c.Evaluate(context.Background()); err == nil {
+		t.Fatal("expected Evaluate to return the error")
+	}
+}
+
+func Test_CachedEvaluator_RefreshAhead(t *testing.T) {
+	var calls int64
+	evaluated := make(chan int64, 10)
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int64, error) {
+		n := atomic.AddInt64(&calls, 1)
+		evaluated <- n
+		return n, nil
+	}, WithClock(fakeClock), WithTimeToLive(time.Minute), WithRefreshAhead(0.5, 0.1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-evaluated
+	fakeClock.Advance(time.Second * 23)
+	if fakeClock.Timers() != 1 {
+		t.Fatal("expected refresher not to evaluate before 40% of the time to live has elapsed")
+	}
+	fakeClock.Advance(time.Second * 7)
+	if n := <-evaluated; n != 2 {
+		t.Fatalf("unexpected evaluation %d", n)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fakeClock.Timers() != 0 {
+		t.Fatal("expected refresher to stop after Close")
+	}
+}
+
+func Test_CachedEvaluator_RefreshAhead_ExpiredValue(t *testing.T) {
+	var calls int64
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	c, err := NewExpiringCachedEvaluator(func(ctx context.Context) (int64, time.Time, error) {
+		return atomic.AddInt64(&calls, 1), fakeClock.Now(), nil
+	}, WithClock(fakeClock), WithRefreshAhead(0.5, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fakeClock.Timers() != 0 || atomic.LoadInt64(&calls) != 1 {
+		t.Fatal("expected refresher not to evaluate a value that has already expired")
+	}
+}
+
+func Test_CachedEvaluator_RefreshAhead_RetriesAfterError(t *testing.T) {
+	var calls int64
+	evaluated := make(chan int64, 10)
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int64, error) {
+		n := atomic.AddInt64(&calls, 1)
+		evaluated <- n
+		if n == 2 {
+			return 0, fmt.Errorf("error %d", n)
+		}
+		return n, nil
+	}, WithClock(fakeClock), WithTimeToLive(time.Minute), WithRefreshAhead(0.5, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-evaluated
+	fakeClock.Advance(time.Second * 30)
+	<-evaluated
+	// The failed background evaluation schedules a retry after half of the remaining 30s.
+	for fakeClock.Timers() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	fakeClock.Advance(time.Second * 15)
+	if n := <-evaluated; n != 3 {
+		t.Fatalf("unexpected evaluation %d", n)
+	}
+}
+
+func Test_CachedEvaluator_Get_ErrorTimeToLive(t *testing.T) {
+	var calls int64
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int, error) {
+		return 0, fmt.Errorf("error %d", atomic.AddInt64(&calls, 1))
+	}, WithErrorTimeToLive(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(context.Background()); err == nil || err.Error() != "error 1" {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if _, err := c.Evaluate(context.Background()); err == nil || err.Error() != "error 2" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := c.Get(context.Background()); err == nil || err.Error() != "error 2" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func Test_CachedEvaluator_Get_ErrorBackoff(t *testing.T) {
+	var calls int64
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int, error) {
+		return 0, fmt.Errorf("error %d", atomic.AddInt64(&calls, 1))
+	}, WithClock(fakeClock), WithErrorBackoff(time.Second, time.Hour, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	fakeClock.Advance(time.Second)
+	if _, err := c.Get(context.Background()); err == nil || err.Error() != "error 2" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// The second backoff period is 2s, so the evaluator is not called again.
+	fakeClock.Advance(time.Second)
+	if _, err := c.Get(context.Background()); err == nil || err.Error() != "error 2" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	fakeClock.Advance(time.Second)
+	if _, err := c.Get(context.Background()); err == nil || err.Error() != "error 3" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func Test_CachedEvaluator_Get_EvaluatorPanics(t *testing.T) {
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int, error) {
+		panic("test panic")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := c.Get(context.Background())
+		panicErr, ok := err.(*PanicError)
+		if !ok {
+			t.Fatalf("expected *PanicError, but got %v", err)
+		}
+		if panicErr.Value != "test panic" || len(panicErr.Stack) == 0 {
+			t.Fatalf("unexpected *PanicError: %+v", panicErr)
+		}
+		if panicErr.Error() != "evaluator panicked: test panic" {
+			t.Fatalf("unexpected error message %#v", panicErr.Error())
+		}
+	}
+}
+
+func Test_CachedEvaluator_Invalidate_DuringEvaluation(t *testing.T) {
+	var calls int64
+	started := make(chan struct{})
+	release := make(chan struct{})
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int64, error) {
+		n := atomic.AddInt64(&calls, 1)
+		if n == 1 {
+			close(started)
+			<-release
+		}
+		return n, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if value, err := c.Get(context.Background()); err != nil || value != 1 {
+			t.Errorf("unexpected result %d, %v", value, err)
+		}
+	}()
+	<-started
+	c.Invalidate()
+	close(release)
+	<-done
+	if _, ok := c.GetCacheOnly(); ok {
+		t.Fatal("expected result of evaluation that was ongoing during Invalidate not to be cached")
+	}
+	if value, err := c.Get(context.Background()); err != nil || value != 2 {
+		t.Fatalf("unexpected result %d, %v", value, err)
+	}
+}
+
+func Test_CachedEvaluator_SetAndInvalidateIf(t *testing.T) {
+	c, err := NewCachedEvaluator(func(ctx context.Context) (string, error) {
+		return "evaluated", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("set")
+	if value, err := c.Get(context.Background()); err != nil || value != "set" {
+		t.Fatalf("unexpected result %#v, %v", value, err)
+	}
+	if c.InvalidateIf(func(value string) bool { return value == "other" }) {
+		t.Fatal("expected InvalidateIf to return false")
+	}
+	if !c.InvalidateIf(func(value string) bool { return value == "set" }) {
+		t.Fatal("expected InvalidateIf to return true")
+	}
+	if value, err := c.Get(context.Background()); err != nil || value != "evaluated" {
+		t.Fatalf("unexpected result %#v, %v", value, err)
+	}
+}
@@ -0,0 +1,24 @@
+package cache
+
+import (
+	"fmt"
+)
+
+// PanicError is the error returned to Goroutines waiting for an evaluation if the evaluator panics. See WithPropagatePanics.
+type PanicError struct {
+	// Stack is the stack trace of the Goroutine that panicked, as returned by runtime/debug.Stack.
+	Stack []byte
+	// Value is the value that was passed to panic.
+	Value interface{}
+}
+
+// Error returns a single-line message that does not include Stack, because the error is typically wrapped and returned to clients.
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("evaluator panicked: %v", p.Value)
+}
+
+// Unwrap returns Value if it is an error.
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"expvar"
+	"time"
+)
+
+type expvarObserver struct {
+	NopObserver
+	cancels                  *expvar.Int
+	evaluationDurationMicros *expvar.Int
+	evaluationErrors         *expvar.Int
+	evaluations              *expvar.Int
+	evaluationsInProgress    *expvar.Int
+	hits                     *expvar.Int
+	misses                   *expvar.Int
+	staleHits                *expvar.Int
+	waiting                  *expvar.Int
+}
+
+// NewExpvarObserver returns an Observer that maintains the following variables in m:
+//   - hits and staleHits: the number of calls to Get that returned a cached value (not stale or stale, respectively).
+//   - misses: the number of calls to Get that had no usable cached value.
+//   - evaluations and evaluationErrors: the number of evaluations that completed (with any result, or with an error, respectively).
+//   - evaluationDurationMicros: the sum of the durations of evaluations that completed, in microseconds.
+//   - evaluationsInProgress: the number of ongoing evaluations.
+//   - cancels: the number of evaluations that were canceled because no Goroutine was waiting for them anymore.
+//   - waiting: the number of Goroutines waiting for an evaluation.
+//
+// Existing variables in m with these names are reused, which allows multiple evaluators to share m.
+// For example: NewExpvarObserver(expvar.NewMap("keyset_cache")).
+func NewExpvarObserver(m *expvar.Map) Observer {
+	newInt := func(name string) *expvar.Int {
+		if v, ok := m.Get(name).(*expvar.Int); ok {
+			return v
+		}
+		v := new(expvar.Int)
+		m.Set(name, v)
+		return v
+	}
+	return &expvarObserver{
+		cancels:                  newInt("cancels"),
+		evaluationDurationMicros: newInt("evaluationDurationMicros"),
+		evaluationErrors:         newInt("evaluationErrors"),
+		evaluations:              newInt("evaluations"),
+		evaluationsInProgress:    newInt("evaluationsInProgress"),
+		hits:                     newInt("hits"),
+		misses:                   newInt("misses"),
+		staleHits:                newInt("staleHits"),
+		waiting:                  newInt("waiting"),
+	}
+}
+
+func (e *expvarObserver) OnHit(stale bool) {
+	if stale {
+		e.staleHits.Add(1)
+	} else {
+		e.hits.Add(1)
+	}
+}
+
+func (e *expvarObserver) OnMiss() {
+	e.misses.Add(1)
+}
+
+func (e *expvarObserver) OnEvaluateStart(ctx context.Context, background bool) context.Context {
+	e.evaluationsInProgress.Add(1)
+	return ctx
+}
+
+func (e *expvarObserver) OnEvaluateEnd(ctx context.Context, duration time.Duration, err error) {
+	e.evaluationsInProgress.Add(-1)
+	e.evaluations.Add(1)
+	e.evaluationDurationMicros.Add(duration.Microseconds())
+	if err != nil {
+		e.evaluationErrors.Add(1)
+	}
+}
+
+func (e *expvarObserver) OnCancel() {
+	e.cancels.Add(1)
+}
+
+func (e *expvarObserver) OnWaitStart() {
+	e.waiting.Add(1)
+}
+
+func (e *expvarObserver) OnWaitEnd(err error) {
+	e.waiting.Add(-1)
+}
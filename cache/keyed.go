@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyedCachedEvaluator is the same as CachedEvaluator, except that it caches a value per key. Evaluations of different keys are
+// independent: for each key at most one Goroutine evaluates at any one time, and the evaluation of a key is canceled when no Goroutine
+// is waiting for it. Keys are evicted in least-recently-used order if the number of keys exceeds the size set by WithMaximumSize.
+// Keys that are in use by a call to Get, Evaluate or Set, or that have an ongoing evaluation, are not evicted, so the number of keys can
+// temporarily exceed the maximum size.
+// Keys whose value has expired (and can no longer be returned as a stale value) are removed periodically, so that memory is reclaimed
+// even if the number of keys is unbounded. See NewKeyedCachedEvaluator.
+type KeyedCachedEvaluator[K comparable, V any] interface {
+	// GetCacheOnly is the same as CachedEvaluator.GetCacheOnly, but for the value of key.
+	GetCacheOnly(key K) (value V, ok bool)
+
+	// Get is the same as CachedEvaluator.Get, but for the value of key.
+	Get(ctx context.Context, key K) (value V, err error)
+
+	// Evaluate is the same as CachedEvaluator.Evaluate, but for the value of key.
+	Evaluate(ctx context.Context, key K) (value V, err error)
+
+	// Invalidate is the same as CachedEvaluator.Invalidate, but for the value of key.
+	Invalidate(key K)
+
+	// InvalidateIf is the same as CachedEvaluator.InvalidateIf, but drops the cached value of each key for which predicate returns
+	// true. InvalidateIf returns the number of dropped values.
+	InvalidateIf(predicate func(key K, value V) bool) int
+
+	// Set is the same as CachedEvaluator.Set, but for the value of key.
+	Set(key K, value V)
+
+	// Close is the same as CachedEvaluator.Close, but for all keys.
+	Close() error
+}
+
+type keyedCachedEvaluator[K comparable, V any] struct {
+	closed    bool
+	elements  map[K]*list.Element
+	evaluator func(ctx context.Context, key K) (V, time.Time, error)
+	// lru contains *keyedCachedEvaluatorElement values, the front being the most recently used.
+	lru         *list.List
+	maximumSize int
+	mutex       sync.Mutex
+	options     []Option
+	// sweepCountdown is the number of keys to add before expired keys are removed, see sweepLockedSection.
+	sweepCountdown int
+}
+
+type keyedCachedEvaluatorElement[K comparable, V any] struct {
+	cachedEvaluator *cachedEvaluator[V]
+	key             K
+	// pins is the number of Goroutines inside a call to Get, Evaluate or Set for key. A pinned key is not evicted or swept, because
+	// the Goroutine may be about to start an evaluation, and removing the key would allow a concurrent evaluation of the same key.
+	// pins is guarded by the mutex of the keyedCachedEvaluator.
+	pins int
+}
+
+// NewKeyedCachedEvaluator returns a cache for calls to evaluator, as defined by KeyedCachedEvaluator. opts apply to the value of each
+// key individually, see NewCachedEvaluator.
+func NewKeyedCachedEvaluator[K comparable, V any](evaluator func(ctx context.Context, key K) (value V, err error),
+	opts ...Option) (KeyedCachedEvaluator[K, V], error) {
+	if evaluator == nil {
+		return nil, fmt.Errorf("evaluator must not be nil")
+	}
+	return NewExpiringKeyedCachedEvaluator(func(ctx context.Context, key K) (value V, expires time.Time, err error) {
+		value, err = evaluator(ctx, key)
+		return
+	}, opts...)
+}
+
+// NewExpiringKeyedCachedEvaluator is the same as NewKeyedCachedEvaluator, except that evaluator also returns the time at which the
+// value expires. See NewExpiringCachedEvaluator.
+func NewExpiringKeyedCachedEvaluator[K comparable, V any](evaluator func(ctx context.Context, key K) (value V, expires time.Time, err error),
+	opts ...Option) (KeyedCachedEvaluator[K, V], error) {
+	if evaluator == nil {
+		return nil, fmt.Errorf("evaluator must not be nil")
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &keyedCachedEvaluator[K, V]{
+		elements:    map[K]*list.Element{},
+		evaluator:   evaluator,
+		lru:         list.New(),
+		maximumSize: o.maximumSize,
+		options:     opts,
+	}, nil
+}
+
+// getLockedSection returns the element of key and marks it as most recently used. If pin is true then the element is pinned and the
+// caller must call unpinLockedSection, and if key has no element then an element is created, evicting the least recently used keys as
+// needed. Otherwise, if key has no element then nil is returned.
+func (k *keyedCachedEvaluator[K, V]) getLockedSection(key K, pin bool) *keyedCachedEvaluatorElement[K, V] {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if element, ok := k.elements[key]; ok {
+		k.lru.MoveToFront(element)
+		e := element.Value.(*keyedCachedEvaluatorElement[K, V])
+		if pin {
+			e.pins++
+		}
+		return e
+	}
+	if !pin {
+		return nil
+	}
+	c, _ := NewExpiringCachedEvaluator(func(ctx context.Context) (V, time.Time, error) {
+		return k.evaluator(ctx, key)
+	}, k.options...)
+	e := &keyedCachedEvaluatorElement[K, V]{
+		cachedEvaluator: c.(*cachedEvaluator[V]),
+		key:             key,
+		pins:            1,
+	}
+	if k.closed {
+		// Do not track c so that it cannot start background evaluations after Close.
+		_ = c.Close()
+		return e
+	}
+	k.sweepCountdown--
+	if k.sweepCountdown <= 0 {
+		k.sweepLockedSection()
+	}
+	k.elements[key] = k.lru.PushFront(e)
+	k.evictLockedSection()
+	return e
+}
+
+// unpinLockedSection removes a pin added by getLockedSection.
+func (k *keyedCachedEvaluator[K, V]) unpinLockedSection(e *keyedCachedEvaluatorElement[K, V]) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	e.pins--
+}
+
+// isRemovable returns true if e is neither pinned nor being evaluated. It must be called with the mutex of the keyedCachedEvaluator
+// locked.
+func (e *keyedCachedEvaluatorElement[K, V]) isRemovable() bool {
+	return e.pins == 0 && !e.cachedEvaluator.isEvaluating()
+}
+
+// sweepLockedSection removes keys that are not pinned and are reclaimable (see cachedEvaluator.isReclaimable). It must be called with
+// k.mutex locked.
+// The next sweep happens after as many keys have been added as remain after this sweep, so that the cost of sweeping is amortized
+// over the keys that are added.
+func (k *keyedCachedEvaluator[K, V]) sweepLockedSection() {
+	for element := k.lru.Front(); element != nil; {
+		next := element.Next()
+		if e := element.Value.(*keyedCachedEvaluatorElement[K, V]); e.pins == 0 && e.cachedEvaluator.isReclaimable() {
+			k.removeLockedSection(element)
+		}
+		element = next
+	}
+	k.sweepCountdown = k.lru.Len()
+}
+
+// evictLockedSection evicts the least recently used keys until the number of keys does not exceed k.maximumSize. Keys that are pinned
+// or have an ongoing evaluation are skipped, because evicting them would allow a concurrent evaluation of the same key. It must be
+// called with k.mutex locked.
+func (k *keyedCachedEvaluator[K, V]) evictLockedSection() {
+	for element := k.lru.Back(); element != nil && k.maximumSize > 0 && k.lru.Len() > k.maximumSize; {
+		previous := element.Prev()
+		if element.Value.(*keyedCachedEvaluatorElement[K, V]).isRemovable() {
+			k.removeLockedSection(element)
+		}
+		element = previous
+	}
+}
+
+// removeLockedSection must be called with k.mutex locked. Goroutines waiting for an evaluation of the removed key are not affected.
+func (k *keyedCachedEvaluator[K, V]) removeLockedSection(element *list.Element) {
+	e := element.Value.(*keyedCachedEvaluatorElement[K, V])
+	k.lru.Remove(element)
+	delete(k.elements, e.key)
+	_ = e.cachedEvaluator.Close()
+}
+
+func (k *keyedCachedEvaluator[K, V]) GetCacheOnly(key K) (value V, ok bool) {
+	e := k.getLockedSection(key, false)
+	if e == nil {
+		return
+	}
+	return e.cachedEvaluator.GetCacheOnly()
+}
+
+func (k *keyedCachedEvaluator[K, V]) Get(ctx context.Context, key K) (value V, err error) {
+	e := k.getLockedSection(key, true)
+	defer k.unpinLockedSection(e)
+	return e.cachedEvaluator.Get(ctx)
+}
+
+func (k *keyedCachedEvaluator[K, V]) Evaluate(ctx context.Context, key K) (value V, err error) {
+	e := k.getLockedSection(key, true)
+	defer k.unpinLockedSection(e)
+	return e.cachedEvaluator.Evaluate(ctx)
+}
+
+func (k *keyedCachedEvaluator[K, V]) Invalidate(key K) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if element, ok := k.elements[key]; ok {
+		k.removeLockedSection(element)
+	}
+}
+
+func (k *keyedCachedEvaluator[K, V]) InvalidateIf(predicate func(key K, value V) bool) int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	n := 0
+	for element := k.lru.Front(); element != nil; {
+		next := element.Next()
+		e := element.Value.(*keyedCachedEvaluatorElement[K, V])
+		if e.cachedEvaluator.InvalidateIf(func(value V) bool {
+			return predicate(e.key, value)
+		}) {
+			k.removeLockedSection(element)
+			n++
+		}
+		element = next
+	}
+	return n
+}
+
+func (k *keyedCachedEvaluator[K, V]) Set(key K, value V) {
+	e := k.getLockedSection(key, true)
+	defer k.unpinLockedSection(e)
+	e.cachedEvaluator.Set(value)
+}
+
+func (k *keyedCachedEvaluator[K, V]) Close() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.closed = true
+	for element := k.lru.Front(); element != nil; element = element.Next() {
+		_ = element.Value.(*keyedCachedEvaluatorElement[K, V]).cachedEvaluator.Close()
+	}
+	return nil
+}
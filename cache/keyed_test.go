@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/test"
+)
+
+func Test_KeyedCachedEvaluator_Get_SingleFlightPerKey(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	k, err := NewKeyedCachedEvaluator(func(ctx context.Context, key string) (string, error) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		return key + "-value", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				value, err := k.Get(context.Background(), key)
+				if err != nil {
+					t.Error(err)
+				} else if value != key+"-value" {
+					t.Errorf("unexpected value %#v", value)
+				}
+			}(key)
+		}
+	}
+	close(release)
+	wg.Wait()
+	if calls != 2 {
+		t.Fatalf("expected evaluator to be called twice, but it was called %d times", calls)
+	}
+}
+
+func Test_KeyedCachedEvaluator_Get_EvictsLeastRecentlyUsed(t *testing.T) {
+	k, err := NewKeyedCachedEvaluator(func(ctx context.Context, key int) (int, error) {
+		return key * 2, nil
+	}, WithMaximumSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for _, key := range []int{1, 2, 1, 3} {
+		if _, err := k.Get(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := k.GetCacheOnly(2); ok {
+		t.Fatal("expected key 2 to be evicted")
+	}
+	for _, key := range []int{1, 3} {
+		if value, ok := k.GetCacheOnly(key); !ok || value != key*2 {
+			t.Fatalf("expected key %d to be cached", key)
+		}
+	}
+}
+
+func Test_KeyedCachedEvaluator_Get_DoesNotEvictEvaluatingKey(t *testing.T) {
+	var calls int64
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	k, err := NewKeyedCachedEvaluator(func(ctx context.Context, key string) (string, error) {
+		if key == "a" {
+			atomic.AddInt64(&calls, 1)
+			started <- struct{}{}
+			<-release
+		}
+		return key + "-value", nil
+	}, WithMaximumSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	var wg sync.WaitGroup
+	getA := func() {
+		defer wg.Done()
+		if _, err := k.Get(context.Background(), "a"); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Add(1)
+	go getA()
+	<-started
+	if _, err := k.Get(context.Background(), "b"); err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(1)
+	go getA()
+	// Give the second Goroutine a chance to start a concurrent evaluation of key a, which is what this test guards against.
+	time.Sleep(time.Millisecond * 10)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expected key a to be evaluated once, but it was evaluated %d times", calls)
+	}
+}
+
+func Test_KeyedCachedEvaluator_Get_DoesNotEvictPinnedKey(t *testing.T) {
+	k, err := NewKeyedCachedEvaluator(func(ctx context.Context, key string) (string, error) {
+		return key + "-value", nil
+	}, WithMaximumSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	kT := k.(*keyedCachedEvaluator[string, string])
+	// Simulate a Goroutine that got the element of key a, but did not start an evaluation yet.
+	e := kT.getLockedSection("a", true)
+	if _, err := k.Get(context.Background(), "b"); err != nil {
+		t.Fatal(err)
+	}
+	if kT.getLockedSection("a", false) != e {
+		t.Fatal("expected pinned key a not to be evicted")
+	}
+	kT.unpinLockedSection(e)
+	if _, err := k.Get(context.Background(), "c"); err != nil {
+		t.Fatal(err)
+	}
+	if kT.getLockedSection("a", false) != nil {
+		t.Fatal("expected unpinned key a to be evicted")
+	}
+}
+
+func Test_KeyedCachedEvaluator_Get_RemovesExpiredKeys(t *testing.T) {
+	fakeClock := test.NewFakeClock(time.Unix(0, 0))
+	k, err := NewKeyedCachedEvaluator(func(ctx context.Context, key int) (int, error) {
+		return key, nil
+	}, WithClock(fakeClock), WithTimeToLive(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for key := 0; key < 200; key++ {
+		if key == 100 {
+			fakeClock.Advance(time.Minute)
+		}
+		if _, err := k.Get(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(k.(*keyedCachedEvaluator[int, int]).elements); n != 100 {
+		t.Fatalf("expected expired keys to be removed, but %d keys remain", n)
+	}
+}
+
+func Test_KeyedCachedEvaluator_Get_PerKeyExpiry(t *testing.T) {
+	var calls int64
+	k, err := NewExpiringKeyedCachedEvaluator(func(ctx context.Context, key string) (int64, time.Time, error) {
+		n := atomic.AddInt64(&calls, 1)
+		if key == "expired" {
+			return n, time.Now().Add(-time.Second), nil
+		}
+		return n, time.Time{}, nil
+	}, WithTimeToLive(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for i := 0; i < 2; i++ {
+		for _, key := range []string{"expired", "fresh"} {
+			if _, err := k.Get(context.Background(), key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("expected evaluator to be called 3 times, but it was called %d times", calls)
+	}
+}
+
+func Test_KeyedCachedEvaluator_InvalidateIf(t *testing.T) {
+	k, err := NewKeyedCachedEvaluator(func(ctx context.Context, key int) (int, error) {
+		return key, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for key := 0; key < 4; key++ {
+		if _, err := k.Get(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k.Set(4, 40)
+	n := k.InvalidateIf(func(key, value int) bool {
+		return value%2 == 0
+	})
+	if n != 3 {
+		t.Fatalf("expected 3 values to be dropped, but got %d", n)
+	}
+	for key := 0; key < 5; key++ {
+		if _, ok := k.GetCacheOnly(key); ok != (key%2 == 1) {
+			t.Fatalf("unexpected cache state for key %d", key)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Observer observes a CachedEvaluator or KeyedCachedEvaluator, for example for the purpose of metrics and tracing. See WithObserver.
+// Methods are called synchronously (some with an internal mutex locked), so they should return quickly and must not call the
+// observed evaluator. Methods must be safe for concurrent use.
+type Observer interface {
+	// OnHit is called when Get returns a cached value without waiting for an evaluation. stale is true if the value has expired (see
+	// WithStaleWhileRevalidate and WithStaleIfError).
+	OnHit(stale bool)
+
+	// OnMiss is called when Get has no usable cached value, and either waits for an evaluation or returns the error of the last
+	// evaluation (see WithErrorTimeToLive).
+	OnMiss()
+
+	// OnEvaluateStart is called before the evaluator is called. background is true if no Goroutine is waiting for the evaluation (see
+	// WithStaleWhileRevalidate and WithRefreshAhead). The returned context is passed to the evaluator and to OnEvaluateEnd, which is
+	// useful for tracing.
+	OnEvaluateStart(ctx context.Context, background bool) context.Context
+
+	// OnEvaluateEnd is called after the evaluator returns. ctx is the context returned by OnEvaluateStart.
+	OnEvaluateEnd(ctx context.Context, duration time.Duration, err error)
+
+	// OnCancel is called when an evaluation is canceled because no Goroutine is waiting for it anymore.
+	OnCancel()
+
+	// OnWaitStart is called when a Goroutine starts waiting for an evaluation.
+	OnWaitStart()
+
+	// OnWaitEnd is called when a Goroutine stops waiting for an evaluation. err is the error returned to the Goroutine.
+	OnWaitEnd(err error)
+}
+
+// NopObserver is an Observer that does nothing. It can be embedded to implement a subset of Observer's methods.
+type NopObserver struct{}
+
+// OnHit implements Observer.
+func (NopObserver) OnHit(stale bool) {}
+
+// OnMiss implements Observer.
+func (NopObserver) OnMiss() {}
+
+// OnEvaluateStart implements Observer.
+func (NopObserver) OnEvaluateStart(ctx context.Context, background bool) context.Context {
+	return ctx
+}
+
+// OnEvaluateEnd implements Observer.
+func (NopObserver) OnEvaluateEnd(ctx context.Context, duration time.Duration, err error) {}
+
+// OnCancel implements Observer.
+func (NopObserver) OnCancel() {}
+
+// OnWaitStart implements Observer.
+func (NopObserver) OnWaitStart() {}
+
+// OnWaitEnd implements Observer.
+func (NopObserver) OnWaitEnd(err error) {}
+
+type multiObserver []Observer
+
+// MultiObserver returns an Observer that forwards all calls to each of observers, in order.
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+func (m multiObserver) OnHit(stale bool) {
+	for _, o := range m {
+		o.OnHit(stale)
+	}
+}
+
+func (m multiObserver) OnMiss() {
+	for _, o := range m {
+		o.OnMiss()
+	}
+}
+
+func (m multiObserver) OnEvaluateStart(ctx context.Context, background bool) context.Context {
+	for _, o := range m {
+		ctx = o.OnEvaluateStart(ctx, background)
+	}
+	return ctx
+}
+
+func (m multiObserver) OnEvaluateEnd(ctx context.Context, duration time.Duration, err error) {
+	for i := len(m) - 1; i >= 0; i-- {
+		m[i].OnEvaluateEnd(ctx, duration, err)
+	}
+}
+
+func (m multiObserver) OnCancel() {
+	for _, o := range m {
+		o.OnCancel()
+	}
+}
+
+func (m multiObserver) OnWaitStart() {
+	for _, o := range m {
+		o.OnWaitStart()
+	}
+}
+
+func (m multiObserver) OnWaitEnd(err error) {
+	for _, o := range m {
+		o.OnWaitEnd(err)
+	}
+}
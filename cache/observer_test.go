@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"testing"
+)
+
+func Test_ExpvarObserver(t *testing.T) {
+	m := new(expvar.Map).Init()
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int, error) {
+		return 1, nil
+	}, WithObserver(NewExpvarObserver(m)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[string]string{
+		"evaluations":           "1",
+		"evaluationErrors":      "0",
+		"evaluationsInProgress": "0",
+		"hits":                  "2",
+		"misses":                "1",
+		"staleHits":             "0",
+		"waiting":               "0",
+	}
+	for name, value := range expected {
+		if actual := m.Get(name).String(); actual != value {
+			t.Errorf("expected %s to be %s, but got %s", name, value, actual)
+		}
+	}
+}
+
+func Test_TracingObserver(t *testing.T) {
+	type spanKey struct{}
+	var ended []error
+	startSpan := func(ctx context.Context, name string) (context.Context, func(err error)) {
+		return context.WithValue(ctx, spanKey{}, name), func(err error) {
+			ended = append(ended, err)
+		}
+	}
+	var spanName interface{}
+	c, err := NewCachedEvaluator(func(ctx context.Context) (int, error) {
+		spanName = ctx.Value(spanKey{})
+		return 0, fmt.Errorf("error")
+	}, WithObserver(MultiObserver(NopObserver{}, NewTracingObserver("evaluate", startSpan))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	if spanName != "evaluate" {
+		t.Fatalf("expected evaluator's context to contain span, but got %#v", spanName)
+	}
+	if len(ended) != 1 || ended[0] == nil {
+		t.Fatalf("expected span to end with error, but got %v", ended)
+	}
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/clock"
+)
+
+// Option is an option that can be passed to NewCachedEvaluator, NewExpiringCachedEvaluator, NewKeyedCachedEvaluator and
+// NewExpiringKeyedCachedEvaluator.
+type Option = func(o *options)
+
+type options struct {
+	clock                clock.Clock
+	errorBackoffInitial  time.Duration
+	errorBackoffJitter   float64
+	errorBackoffMaximum  time.Duration
+	errorTimeToLive      time.Duration
+	maximumSize          int
+	observer             Observer
+	propagatePanics      bool
+	refreshAheadFraction float64
+	refreshAheadJitter   float64
+	staleIfError         time.Duration
+	staleWhileRevalidate time.Duration
+	timeToLive           time.Duration
+}
+
+// WithClock returns an option that sets the clock that is used for expiry, backoff and the refresher. This is useful for unit testing,
+// see test.NewFakeClock. The default is clock.System.
+func WithClock(v clock.Clock) Option {
+	return func(o *options) {
+		o.clock = v
+	}
+}
+
+// WithErrorBackoff returns an option that enables exponential backoff after failed evaluations. After n consecutive failed evaluations
+// Get does not evaluate for a period of initial*2^(n-1), limited to maximum, minus a random jitter that is uniformly distributed in
+// [0, jitter) (as a fraction of the period). During this period Get returns the error of the last evaluation. Evaluate is not affected.
+func WithErrorBackoff(initial, maximum time.Duration, jitter float64) Option {
+	if initial <= 0 {
+		panic(fmt.Errorf("initial must be positive"))
+	}
+	if maximum < initial {
+		panic(fmt.Errorf("maximum must not be less than initial"))
+	}
+	if jitter < 0 || jitter >= 1 {
+		panic(fmt.Errorf("jitter must be in [0, 1)"))
+	}
+	return func(o *options) {
+		o.errorBackoffInitial = initial
+		o.errorBackoffJitter = jitter
+		o.errorBackoffMaximum = maximum
+	}
+}
+
+// WithErrorTimeToLive returns an option that enables negative caching: after a failed evaluation Get does not evaluate for the period v
+// and returns the error of the failed evaluation instead. Evaluate is not affected. If WithErrorBackoff is also set then the longer of
+// the two periods is used.
+func WithErrorTimeToLive(v time.Duration) Option {
+	if v < 0 {
+		panic(fmt.Errorf("v must be non-negative"))
+	}
+	return func(o *options) {
+		o.errorTimeToLive = v
+	}
+}
+
+// WithMaximumSize returns an option for NewKeyedCachedEvaluator that sets the maximum number of keys. If the maximum number of keys is
+// exceeded then the least recently used key that is not being evaluated is evicted. A maximum size of 0 means the number of keys is
+// unbounded, although keys whose value has expired are still removed (see KeyedCachedEvaluator).
+// This option has no effect on a CachedEvaluator.
+func WithMaximumSize(v int) Option {
+	if v < 0 {
+		panic(fmt.Errorf("v must be non-negative"))
+	}
+	return func(o *options) {
+		o.maximumSize = v
+	}
+}
+
+// WithObserver returns an option that sets an Observer, for example for the purpose of metrics and tracing. See NewExpvarObserver,
+// NewTracingObserver and MultiObserver.
+func WithObserver(v Observer) Option {
+	return func(o *options) {
+		o.observer = v
+	}
+}
+
+// WithPropagatePanics returns an option that sets whether a panic of the evaluator is propagated. By default, a panic is recovered and
+// Goroutines waiting for the evaluation get a *PanicError. If v is true then Goroutines waiting for the evaluation still get a
+// *PanicError, but the panic is also propagated, which crashes the process.
+func WithPropagatePanics(v bool) Option {
+	return func(o *options) {
+		o.propagatePanics = v
+	}
+}
+
+// WithRefreshAhead returns an option that enables a refresher that evaluates in the background before the cached value expires, so that
+// Goroutines calling Get do not have to wait for an evaluation. The refresher evaluates after a fraction of the value's time to live
+// has elapsed, minus a random jitter that is uniformly distributed in [0, jitter) (also as a fraction of the value's time to live).
+// The jitter avoids many processes evaluating at the same time. For example, WithRefreshAhead(0.8, 0.1) evaluates between 70% and 80% of
+// the value's time to live. Background evaluations run on a context that is canceled by Close.
+// The refresher waits at least MinimumRefreshAheadDelay, so values that expire sooner than that (including values that have already
+// expired when they are evaluated) are not refreshed ahead. If a background evaluation fails then the refresher retries after the same
+// fraction of the remaining time to live (or after the period set by WithErrorTimeToLive or WithErrorBackoff, if that is longer).
+func WithRefreshAhead(fraction, jitter float64) Option {
+	if fraction <= 0 || fraction > 1 {
+		panic(fmt.Errorf("fraction must be in (0, 1]"))
+	}
+	if jitter < 0 || jitter > fraction {
+		panic(fmt.Errorf("jitter must be in [0, fraction]"))
+	}
+	return func(o *options) {
+		o.refreshAheadFraction = fraction
+		o.refreshAheadJitter = jitter
+	}
+}
+
+// WithStaleIfError returns an option that sets the period after a value expires during which the value is returned by Get if the
+// evaluation fails. In other words: the value's hard deadline. This is similar to the stale-if-error Cache-Control extension defined by
+// https://tools.ietf.org/html/rfc5861.
+func WithStaleIfError(v time.Duration) Option {
+	if v < 0 {
+		panic(fmt.Errorf("v must be non-negative"))
+	}
+	return func(o *options) {
+		o.staleIfError = v
+	}
+}
+
+// WithStaleWhileRevalidate returns an option that sets the period after a value expires during which Get immediately returns the
+// stale value while one Goroutine evaluates in the background. This is similar to the stale-while-revalidate Cache-Control extension
+// defined by https://tools.ietf.org/html/rfc5861.
+func WithStaleWhileRevalidate(v time.Duration) Option {
+	if v < 0 {
+		panic(fmt.Errorf("v must be non-negative"))
+	}
+	return func(o *options) {
+		o.staleWhileRevalidate = v
+	}
+}
+
+// WithTimeToLive returns an option that sets the time to live of cached values. The time to live is used for values for which no
+// expiry time is known. A time to live of 0 means such values never expire.
+func WithTimeToLive(v time.Duration) Option {
+	if v < 0 {
+		panic(fmt.Errorf("v must be non-negative"))
+	}
+	return func(o *options) {
+		o.timeToLive = v
+	}
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// StartSpanFunc starts a span named name as a child of the span in ctx (if any). It returns a context containing the new span and a
+// function that ends the span, recording err if it is not nil. This matches the shape of most tracing libraries, for example for
+// OpenTelemetry:
+//
+//	func(ctx context.Context, name string) (context.Context, func(err error)) {
+//		ctx, span := tracer.Start(ctx, name)
+//		return ctx, func(err error) {
+//			if err != nil {
+//				span.RecordError(err)
+//				span.SetStatus(codes.Error, err.Error())
+//			}
+//			span.End()
+//		}
+//	}
+type StartSpanFunc = func(ctx context.Context, name string) (context.Context, func(err error))
+
+type tracingObserver struct {
+	NopObserver
+	name      string
+	startSpan StartSpanFunc
+}
+
+// NewTracingObserver returns an Observer that wraps each evaluation in a span named name, started via startSpan. The context passed to
+// the evaluator contains the span. Because evaluations are shared by Goroutines, the span of an evaluation is not a child of the span
+// of any Goroutine calling Get.
+func NewTracingObserver(name string, startSpan StartSpanFunc) Observer {
+	return &tracingObserver{
+		name:      name,
+		startSpan: startSpan,
+	}
+}
+
+func (t *tracingObserver) OnEvaluateStart(ctx context.Context, background bool) context.Context {
+	ctx, endSpan := t.startSpan(ctx, t.name)
+	return context.WithValue(ctx, t, endSpan)
+}
+
+func (t *tracingObserver) OnEvaluateEnd(ctx context.Context, duration time.Duration, err error) {
+	if endSpan, ok := ctx.Value(t).(func(err error)); ok {
+		endSpan(err)
+	}
+}
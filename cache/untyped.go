@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+)
+
+// UntypedCachedEvaluator is the interface{} based predecessor of CachedEvaluator. A nil value means no value is cached.
+// See NewUntypedCachedEvaluator.
+//
+// Deprecated: use CachedEvaluator.
+type UntypedCachedEvaluator interface {
+	GetCacheOnly() (value interface{})
+
+	Get(ctx context.Context) (value interface{}, err error)
+
+	// Evaluate is the same as Get, except:
+	// Evaluate always ensures a Goroutine is evaluating. If there are no Goroutines evaluating then
+	// evaluator is called.
+	Evaluate(ctx context.Context) (value interface{}, err error)
+}
+
+type untypedCachedEvaluator struct {
+	c CachedEvaluator[interface{}]
+}
+
+// NewUntypedCachedEvaluator returns a cache for calls to evaluator, as defined by UntypedCachedEvaluator.
+// A value is cached if and only if evaluator returns a nil error and a non-nil value.
+//
+// Deprecated: use NewCachedEvaluator.
+func NewUntypedCachedEvaluator(evaluator func(ctx context.Context) (value interface{}, err error)) (UntypedCachedEvaluator, error) {
+	c, err := NewCachedEvaluator(evaluator)
+	if err != nil {
+		return nil, err
+	}
+	return &untypedCachedEvaluator{
+		c: c,
+	}, nil
+}
+
+func (u *untypedCachedEvaluator) GetCacheOnly() (value interface{}) {
+	value, _ = u.c.GetCacheOnly()
+	return
+}
+
+func (u *untypedCachedEvaluator) Get(ctx context.Context) (value interface{}, err error) {
+	value = u.GetCacheOnly()
+	if value != nil {
+		return
+	}
+	return u.c.Evaluate(ctx)
+}
+
+func (u *untypedCachedEvaluator) Evaluate(ctx context.Context) (value interface{}, err error) {
+	return u.c.Evaluate(ctx)
+}
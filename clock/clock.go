@@ -0,0 +1,50 @@
+package clock
+
+import (
+	"time"
+)
+
+// Clock is an abstraction of time for the purpose of unit testing. See System and test.NewFakeClock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// AfterFunc waits for d to elapse and then calls f in its own Goroutine. The returned Timer can be used to cancel the call.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer created by Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the Timer from firing. It returns true if the call stops the timer, false if the timer has already fired or been
+	// stopped.
+	Stop() bool
+}
+
+type systemClock struct{}
+
+// System is the Clock of the operating system, as exposed by package time.
+var System Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type funcClock func() time.Time
+
+// Func returns a Clock whose Now method calls now. Timers created by the returned Clock are the same as those created by System.
+// This is useful for adapting time sources of the form func() time.Time.
+func Func(now func() time.Time) Clock {
+	return funcClock(now)
+}
+
+func (f funcClock) Now() time.Time {
+	return f()
+}
+
+func (f funcClock) AfterFunc(d time.Duration, g func()) Timer {
+	return time.AfterFunc(d, g)
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheControl represents the directives of RFC7234 Cache-Control headers that are relevant to clients.
+// See https://tools.ietf.org/html/rfc7234#section-5.2
+type CacheControl struct {
+	// MaxAge is the value of the max-age directive. MaxAge is only meaningful if HasMaxAge is true.
+	MaxAge    time.Duration
+	HasMaxAge bool
+	// NoCache is true if an unqualified no-cache directive is present. A qualified no-cache directive (such as no-cache="Set-Cookie")
+	// only applies to the listed header fields, and is ignored.
+	NoCache bool
+	// NoStore is true if an unqualified no-store directive is present.
+	NoStore bool
+}
+
+// ParseCacheControl parses the Cache-Control headers of header. Unknown directives are ignored.
+func ParseCacheControl(header http.Header) (*CacheControl, error) {
+	c := &CacheControl{}
+	for _, headerValue := range header.Values(HeaderNameCacheControl) {
+		if err := parseCacheControlHeaderValue(c, headerValue); err != nil {
+			return nil, fmt.Errorf("a header named %s has an invalid value: %w", HeaderNameCacheControl, err)
+		}
+	}
+	return c, nil
+}
+
+func parseCacheControlHeaderValue(c *CacheControl, headerValue string) error {
+	for i := 0; i < len(headerValue); {
+		if headerValue[i] == ',' || headerValue[i] == ' ' || headerValue[i] == '\t' {
+			i++
+			continue
+		}
+		name, value, hasValue, n, err := parseCacheControlDirective(headerValue[i:])
+		if err != nil {
+			return err
+		}
+		i += n
+		switch strings.ToLower(name) {
+		case "max-age":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds < 0 {
+				return fmt.Errorf("directive max-age has invalid value %#v", value)
+			}
+			if seconds > int64(time.Duration(1<<63-1)/time.Second) {
+				c.MaxAge = time.Duration(1<<63 - 1)
+			} else {
+				c.MaxAge = time.Duration(seconds) * time.Second
+			}
+			c.HasMaxAge = true
+		case "no-cache":
+			// A qualified no-cache (for example no-cache="Set-Cookie") only applies to the listed header fields, see
+			// https://tools.ietf.org/html/rfc7234#section-5.2.2.2
+			if !hasValue {
+				c.NoCache = true
+			}
+		case "no-store":
+			if !hasValue {
+				c.NoStore = true
+			}
+		}
+	}
+	return nil
+}
+
+// parseCacheControlDirective parses the directive at the start of s, of the form token [ "=" ( token / quoted-string ) ], and returns
+// the number of bytes parsed, including whitespace before the next comma. The value of a quoted-string is returned unquoted.
+func parseCacheControlDirective(s string) (name, value string, hasValue bool, n int, err error) {
+	for n < len(s) && s[n] != '=' && s[n] != ',' && s[n] != ' ' && s[n] != '\t' {
+		n++
+	}
+	name = s[:n]
+	if !IsToken(name) {
+		err = fmt.Errorf("directive name %#v is not a token", name)
+		return
+	}
+	if n < len(s) && s[n] == '=' {
+		hasValue = true
+		n++
+		if n < len(s) && s[n] == '"' {
+			var b strings.Builder
+			n++
+			for {
+				if n >= len(s) {
+					err = fmt.Errorf("directive %s has an unterminated quoted-string value", name)
+					return
+				}
+				if s[n] == '"' {
+					n++
+					break
+				}
+				if s[n] == '\\' && n+1 < len(s) {
+					n++
+				}
+				b.WriteByte(s[n])
+				n++
+			}
+			value = b.String()
+		} else {
+			start := n
+			for n < len(s) && s[n] != ',' && s[n] != ' ' && s[n] != '\t' {
+				n++
+			}
+			value = s[start:n]
+			if !IsToken(value) {
+				err = fmt.Errorf("directive %s has value %#v, which is neither a token nor a quoted-string", name, value)
+				return
+			}
+		}
+	}
+	for n < len(s) && (s[n] == ' ' || s[n] == '\t') {
+		n++
+	}
+	if n < len(s) && s[n] != ',' {
+		err = fmt.Errorf("directive %s is not followed by a comma", name)
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_ParseCacheControl_Success(t *testing.T) {
+	header := http.Header{}
+	header.Add("Cache-Control", "public, max-age=19951, must-revalidate")
+	header.Add("Cache-Control", `no-cache="Set-Cookie, Set-Cookie2", no-store`)
+	c, err := ParseCacheControl(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A qualified no-cache only applies to the listed header fields.
+	if !c.HasMaxAge || c.MaxAge != time.Second*19951 || c.NoCache || !c.NoStore {
+		t.Fatalf("unexpected result %+v", c)
+	}
+}
+
+func Test_ParseCacheControl_UnqualifiedNoCache(t *testing.T) {
+	header := http.Header{}
+	header.Add("Cache-Control", `private="a,b" , no-cache,max-age="60"`)
+	c, err := ParseCacheControl(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.NoCache || !c.HasMaxAge || c.MaxAge != time.Minute {
+		t.Fatalf("unexpected result %+v", c)
+	}
+}
+
+func Test_ParseCacheControl_InvalidMaxAge(t *testing.T) {
+	header := http.Header{}
+	header.Add("Cache-Control", "max-age=-1")
+	_, err := ParseCacheControl(header)
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func Test_ParseCacheControl_UnterminatedQuotedString(t *testing.T) {
+	header := http.Header{}
+	header.Add("Cache-Control", `no-cache="Set-Cookie, max-age=60`)
+	if _, err := ParseCacheControl(header); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -1,8 +1,20 @@
 package http
 
 const (
+	// HeaderNameAge is the name of the Age header
+	HeaderNameAge = "Age"
 	// HeaderNameAuthorization is the name of the Authorization header
 	HeaderNameAuthorization = "Authorization"
+	// HeaderNameCacheControl is the name of the Cache-Control header
+	HeaderNameCacheControl = "Cache-Control"
+	// HeaderNameDate is the name of the Date header
+	HeaderNameDate = "Date"
+	// HeaderNameETag is the name of the ETag header
+	HeaderNameETag = "ETag"
+	// HeaderNameExpires is the name of the Expires header
+	HeaderNameExpires = "Expires"
+	// HeaderNameLastModified is the name of the Last-Modified header
+	HeaderNameLastModified = "Last-Modified"
 	// HeaderNameWWWAuthenticate is the name of the WWW-Authenticate header
 	HeaderNameWWWAuthenticate = "WWW-Authenticate"
 )
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jbrekelmans/go-lib/clock"
+)
+
+// FakeClock is a clock.Clock whose time only changes when Advance or Set is called. Timers created via AfterFunc fire during the call
+// to Advance or Set that makes their deadline elapse. As promised by clock.Clock, the function of each timer is called in its own
+// Goroutine, so tests must call Wait before observing the effects of timers. See NewFakeClock.
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// running is the number of functions of fired timers that have not returned.
+	running sync.WaitGroup
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	f        func()
+}
+
+// NewFakeClock returns a FakeClock whose current time is now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now: now,
+	}
+}
+
+// Now implements clock.Clock.
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// AfterFunc implements clock.Clock.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) clock.Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{
+		clock:    c,
+		deadline: c.now.Add(d),
+		f:        f,
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance advances the current time by d. See Set.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set sets the current time to now and fires timers whose deadline is not after now, in order of deadline. The function of each
+// fired timer is called in its own Goroutine, so the functions may run concurrently and in any order. See Wait.
+func (c *FakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	c.now = now
+	c.mutex.Unlock()
+	for {
+		t := c.popDueTimer()
+		if t == nil {
+			return
+		}
+		c.running.Add(1)
+		go func() {
+			defer c.running.Done()
+			t.f()
+		}()
+	}
+}
+
+// Wait waits for the functions of all fired timers to return.
+func (c *FakeClock) Wait() {
+	c.running.Wait()
+}
+
+// Timers returns the number of timers that have neither fired nor been stopped.
+func (c *FakeClock) Timers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+func (c *FakeClock) popDueTimer() *fakeTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+	if len(c.timers) == 0 || c.timers[0].deadline.After(c.now) {
+		return nil
+	}
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+	return t
+}
+
+// Stop implements clock.Timer.
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, t2 := range c.timers {
+		if t2 == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}